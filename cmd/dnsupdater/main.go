@@ -2,6 +2,7 @@ package main
 
 import (
 	"context"
+	"fmt"
 	"log/slog"
 	"net"
 	"net/http"
@@ -13,47 +14,175 @@ import (
 
 	"github.com/erkki/dnsupdater/internal/cache"
 	"github.com/erkki/dnsupdater/internal/config"
+	"github.com/erkki/dnsupdater/internal/desired"
 	"github.com/erkki/dnsupdater/internal/ipcheck"
+	"github.com/erkki/dnsupdater/internal/provider"
+	"github.com/erkki/dnsupdater/internal/rfc2136"
+	"github.com/erkki/dnsupdater/internal/rules"
 	"github.com/erkki/dnsupdater/internal/spaceship"
 	"github.com/erkki/dnsupdater/internal/updater"
 )
 
+const usage = `usage: dnsupdater [command]
+
+commands:
+  run                     keep records in sync until interrupted (default)
+  plan [-format table|json] [-out file]
+                          show the changes a sync would make
+  apply <plan file>       apply a saved plan if live records still match it
+`
+
 func main() {
 	_ = godotenv.Load()
 
+	command, args := "run", os.Args[1:]
+	if len(args) > 0 {
+		command, args = args[0], args[1:]
+	}
+	switch command {
+	case "run", "plan", "apply":
+	default:
+		fmt.Fprint(os.Stderr, usage)
+		os.Exit(2)
+	}
+
 	cfg, err := config.Load()
 	if err != nil {
 		panic(err)
 	}
 
-	logger := slog.New(slog.NewJSONHandler(os.Stdout, nil))
+	// plan and apply print their result on stdout, so logs go to stderr.
+	logOutput := os.Stdout
+	if command != "run" {
+		logOutput = os.Stderr
+	}
+	logger := slog.New(slog.NewJSONHandler(logOutput, nil))
 
-	var mockIP net.IP
-	if cfg.MockIP != "" {
-		mockIP = net.ParseIP(cfg.MockIP)
+	mockIPs := make(map[ipcheck.Family]net.IP)
+	for _, raw := range []string{cfg.MockIP, cfg.MockIPv6} {
+		if raw == "" {
+			continue
+		}
+		mockIP := net.ParseIP(raw)
 		if mockIP == nil {
-			logger.Error("invalid MOCK_IP", "ip", cfg.MockIP)
+			logger.Error("invalid mock IP", "ip", raw)
+			os.Exit(1)
+		}
+		family := ipcheck.FamilyOf(mockIP)
+		mockIPs[family] = mockIP
+		logger.Info("using mock IP", "family", family, "ip", mockIP.String())
+	}
+
+	mode, err := ipcheck.ParseMode(cfg.IPDetection)
+	if err != nil {
+		logger.Error("invalid IP_DETECTION", "err", err)
+		os.Exit(1)
+	}
+	var fetchers []*ipcheck.Fetcher
+	for _, family := range cfg.IPFamilies {
+		list := cfg.IPCheckEndpoints
+		if family == ipcheck.IPv6 {
+			list = cfg.IPv6Endpoints
+		}
+		endpoints := cfg.IPEndpointDefs
+		if len(endpoints) == 0 {
+			endpoints, err = ipcheck.ParseEndpoints(list)
+			if err != nil {
+				logger.Error("invalid IP endpoint", "family", family, "err", err)
+				os.Exit(1)
+			}
+		}
+		fetcher := ipcheck.NewFetcher(family, ipcheck.NewHTTPClient(family), nil, mockIPs[family])
+		fetcher.SetEndpoints(endpoints)
+		if n := len(fetcher.Endpoints()); mode == ipcheck.ModeQuorum && cfg.IPQuorum > n {
+			logger.Error("IP_QUORUM exceeds the number of endpoints", "family", family, "quorum", cfg.IPQuorum, "endpoints", n)
 			os.Exit(1)
 		}
-		logger.Info("using mock IP", "ip", mockIP.String())
+		fetcher.SetMode(mode)
+		fetcher.SetQuorum(cfg.IPQuorum)
+		fetcher.SetStagger(cfg.IPRaceStagger)
+		fetcher.SetLogger(logger)
+		fetchers = append(fetchers, fetcher)
 	}
 
-	httpClient := &http.Client{}
-	fetcher := ipcheck.NewFetcher(httpClient, cfg.IPCheckEndpoints, mockIP)
-	cache := cache.NewMemoryCache()
-	shipClient := spaceship.NewClient(cfg.BaseURL, cfg.APIKey, cfg.APISecret, httpClient)
+	store, err := cache.Open(cfg.CacheBackend, cfg.CachePath)
+	if err != nil {
+		logger.Error("failed to open cache", "backend", cfg.CacheBackend, "path", cfg.CachePath, "err", err)
+		os.Exit(1)
+	}
+	defer store.Close()
+	if fileCache, ok := store.(*cache.FileCache); ok && fileCache.Recovered() != "" {
+		logger.Warn("cache file was corrupt and has been moved aside", "path", cfg.CachePath, "moved_to", fileCache.Recovered())
+	}
 
-	up := updater.New(logger, fetcher, cache, shipClient, cfg.PollInterval, cfg.DryRun)
+	dnsProvider, err := newProvider(cfg)
+	if err != nil {
+		logger.Error("failed to set up DNS provider", "provider", cfg.Provider, "err", err)
+		os.Exit(1)
+	}
+
+	selector, err := rules.New(cfg.RecordInclude, cfg.RecordExclude)
+	if err != nil {
+		logger.Error("invalid record rules", "err", err)
+		os.Exit(1)
+	}
+
+	var state *desired.State
+	if cfg.DesiredStateFile != "" {
+		state, err = desired.Load(cfg.DesiredStateFile)
+		if err != nil {
+			logger.Error("failed to load desired state", "err", err)
+			os.Exit(1)
+		}
+		caps := dnsProvider.Capabilities()
+		for _, spec := range state.Records {
+			if !caps.Supports(spec.Type) {
+				logger.Error("provider cannot write record type", "provider", dnsProvider.Name(), "type", spec.Type, "domain", spec.Domain, "name", spec.Name)
+				os.Exit(1)
+			}
+			// A clamped TTL would never match the declared one, so the
+			// record would be rewritten every cycle.
+			if !caps.SupportsTTL(spec.TTL) {
+				logger.Error("provider does not accept the record's TTL", "provider", dnsProvider.Name(), "ttl", spec.TTL, "min", caps.MinTTL, "max", caps.MaxTTL, "domain", spec.Domain, "name", spec.Name, "type", spec.Type)
+				os.Exit(1)
+			}
+		}
+		logger.Info("using desired state", "path", cfg.DesiredStateFile, "records", len(state.Records), "prune", state.Prune)
+	}
+
+	up := updater.New(logger, fetchers, store, dnsProvider, updater.Options{
+		PollInterval:    cfg.PollInterval,
+		RefreshInterval: cfg.RefreshInterval,
+		RetryInterval:   cfg.RetryInterval,
+		DryRun:          cfg.DryRun,
+		Strategy:        updater.Strategy(cfg.UpdateStrategy),
+		Selector:        selector,
+		Desired:         state,
+	})
 
 	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
 	defer stop()
 
+	if command != "run" {
+		run := runPlan
+		if command == "apply" {
+			run = runApply
+		}
+		code := run(ctx, logger, up, args)
+		logProviderStats(logger, dnsProvider)
+		// os.Exit skips deferred calls.
+		store.Close()
+		os.Exit(code)
+	}
+
 	if err := up.LoadRecords(ctx); err != nil {
 		logger.Error("failed to load records", "err", err)
 		os.Exit(1)
 	}
 
-	if err := up.Run(ctx); err != nil {
+	err = up.Run(ctx)
+	logProviderStats(logger, dnsProvider)
+	if err != nil {
 		if err == context.Canceled {
 			logger.Info("shutdown requested")
 			return
@@ -62,3 +191,52 @@ func main() {
 		os.Exit(1)
 	}
 }
+
+// burst allows short bursts of twice the per-second rate.
+func burst(rate float64) int {
+	return max(1, int(2*rate))
+}
+
+// logProviderStats logs how long API calls waited for the rate limiter.
+func logProviderStats(logger *slog.Logger, p provider.DNSProvider) {
+	if client, ok := p.(*spaceship.Client); ok {
+		stats := client.RateLimitStats()
+		logger.Info("spaceship rate limiting",
+			"list_requests", stats.List.Requests, "list_waits", stats.List.Waits, "list_waited", stats.List.Waited.String(),
+			"write_requests", stats.Write.Requests, "write_waits", stats.Write.Waits, "write_waited", stats.Write.Waited.String())
+	}
+}
+
+// newProvider returns the DNS provider selected by cfg.Provider.
+func newProvider(cfg config.Config) (provider.DNSProvider, error) {
+	switch cfg.Provider {
+	case "spaceship":
+		client := spaceship.NewClient(cfg.BaseURL, cfg.APIKey, cfg.APISecret, &http.Client{})
+		retry := spaceship.DefaultRetryPolicy()
+		retry.MaxAttempts = cfg.MaxAttempts
+		client.SetRetryPolicy(retry)
+		client.SetRateLimits(spaceship.RateLimits{
+			List:  spaceship.Limit{Rate: cfg.ListRate, Burst: burst(cfg.ListRate)},
+			Write: spaceship.Limit{Rate: cfg.WriteRate, Burst: burst(cfg.WriteRate)},
+		})
+		client.SetFetchOptions(spaceship.FetchOptions{Workers: cfg.FetchWorkers, Partial: cfg.FetchPartial})
+		return client, nil
+	case "rfc2136":
+		rc := rfc2136.Config{
+			Server:    cfg.RFC2136.Server,
+			Zones:     cfg.RFC2136.Zones,
+			Transport: cfg.RFC2136.Transport,
+			Names:     cfg.RFC2136.Names,
+		}
+		if cfg.RFC2136.KeyName != "" {
+			rc.Key = &rfc2136.TSIGKey{
+				Name:      cfg.RFC2136.KeyName,
+				Algorithm: cfg.RFC2136.KeyAlgorithm,
+				Secret:    cfg.RFC2136.KeySecret,
+			}
+		}
+		return rfc2136.New(rc)
+	default:
+		return nil, fmt.Errorf("unknown provider %q", cfg.Provider)
+	}
+}
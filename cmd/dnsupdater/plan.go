@@ -0,0 +1,83 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+
+	"github.com/erkki/dnsupdater/internal/desired"
+	"github.com/erkki/dnsupdater/internal/updater"
+)
+
+// runPlan prints the changes a sync would make and optionally saves them for
+// apply. It returns the process exit code.
+func runPlan(ctx context.Context, logger *slog.Logger, up *updater.Updater, args []string) int {
+	fs := flag.NewFlagSet("plan", flag.ContinueOnError)
+	format := fs.String("format", "table", "output format: table or json")
+	out := fs.String("out", "", "save the plan to this file for apply")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if *format != "table" && *format != "json" {
+		fmt.Fprintf(os.Stderr, "invalid -format %q, want table or json\n", *format)
+		return 2
+	}
+
+	plan, err := up.Plan(ctx)
+	if err != nil {
+		logger.Error("plan failed", "err", err)
+		return 1
+	}
+	if *format == "json" {
+		err = plan.WriteJSON(os.Stdout)
+	} else {
+		err = plan.WriteTable(os.Stdout)
+	}
+	if err != nil {
+		logger.Error("failed to print plan", "err", err)
+		return 1
+	}
+	if *out != "" {
+		if err := plan.Save(*out); err != nil {
+			logger.Error("failed to save plan", "path", *out, "err", err)
+			return 1
+		}
+		logger.Info("saved plan", "path", *out, "changes", len(plan.Changes))
+	}
+	return 0
+}
+
+// runApply executes a plan saved by runPlan. It returns the process exit code.
+func runApply(ctx context.Context, logger *slog.Logger, up *updater.Updater, args []string) int {
+	fs := flag.NewFlagSet("apply", flag.ContinueOnError)
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if fs.NArg() != 1 {
+		fmt.Fprint(os.Stderr, "usage: dnsupdater apply <plan file>\n")
+		return 2
+	}
+
+	plan, err := updater.LoadPlan(fs.Arg(0))
+	if err != nil {
+		logger.Error("failed to load plan", "err", err)
+		return 1
+	}
+	results, err := up.Apply(ctx, plan)
+	if errors.Is(err, desired.ErrStale) {
+		logger.Error("refusing to apply plan, run plan again", "created_at", plan.CreatedAt, "err", err)
+		return 1
+	}
+	for _, res := range results {
+		logger.Info("domain applied", "domain", res.Domain, "changes", res.Records, "err", res.Err, "rolled_back", res.RolledBack)
+	}
+	if err != nil {
+		logger.Error("apply failed", "err", err)
+		return 1
+	}
+	fmt.Printf("Applied: %s.\n", plan.Summary())
+	return 0
+}
@@ -1,37 +1,158 @@
 package cache
 
 import (
+	"bytes"
+	"fmt"
 	"net"
 	"sync"
+	"time"
 )
 
-// MemoryCache stores IP state in memory.
+// historyLimit caps the number of entries kept per family.
+const historyLimit = 50
+
+// Backend names accepted by Open.
+const (
+	BackendMemory = "memory"
+	BackendFile   = "file"
+	BackendKV     = "kv"
+)
+
+// Entry is the state recorded for one address family after a successful update.
+type Entry struct {
+	IP        net.IP
+	UpdatedAt time.Time
+	// Records identifies the records the IP was applied to.
+	Records []string
+}
+
+func (e Entry) clone() *Entry {
+	c := Entry{UpdatedAt: e.UpdatedAt}
+	if e.IP != nil {
+		c.IP = make(net.IP, len(e.IP))
+		copy(c.IP, e.IP)
+	}
+	if e.Records != nil {
+		c.Records = append([]string(nil), e.Records...)
+	}
+	return &c
+}
+
+// Store persists updater state between sync cycles.
+type Store interface {
+	// Load returns the latest entry for family, or nil if nothing was saved yet.
+	Load(family string) (*Entry, error)
+	// Save records entry as the latest for family and appends it to the history.
+	Save(family string, entry Entry) error
+	// History returns up to limit saved entries for family, newest first.
+	// A limit of zero or less returns everything kept.
+	History(family string, limit int) ([]Entry, error)
+	// State returns the value stored under key, or nil if it is unset.
+	State(key string) ([]byte, error)
+	// SetState stores value under key. A nil value removes the key.
+	SetState(key string, value []byte) error
+	// Close releases any resources held by the store.
+	Close() error
+}
+
+// Open returns the store for backend. The file and kv backends keep their
+// data at path.
+func Open(backend, path string) (Store, error) {
+	switch backend {
+	case BackendMemory:
+		return NewMemoryCache(), nil
+	case BackendFile:
+		if path == "" {
+			return nil, fmt.Errorf("cache backend %q requires a path", backend)
+		}
+		return NewFileCache(path)
+	case BackendKV:
+		if path == "" {
+			return nil, fmt.Errorf("cache backend %q requires a path", backend)
+		}
+		return NewKVCache(path)
+	default:
+		return nil, fmt.Errorf("unknown cache backend %q", backend)
+	}
+}
+
+// MemoryCache stores IP state in memory, keyed by address family.
 type MemoryCache struct {
-	mu  sync.RWMutex
-	ip  net.IP
+	mu      sync.RWMutex
+	history map[string][]*Entry
+	state   map[string][]byte
 }
 
 func NewMemoryCache() *MemoryCache {
-	return &MemoryCache{}
+	return &MemoryCache{history: make(map[string][]*Entry), state: make(map[string][]byte)}
 }
 
-func (c *MemoryCache) Load() (net.IP, error) {
+// Load returns the entry for family, or nil if nothing was saved yet.
+func (c *MemoryCache) Load(family string) (*Entry, error) {
 	c.mu.RLock()
 	defer c.mu.RUnlock()
-	if c.ip == nil {
+	entries := c.history[family]
+	if len(entries) == 0 {
 		return nil, nil
 	}
 	// Return a copy to prevent external modification
-	ip := make(net.IP, len(c.ip))
-	copy(ip, c.ip)
-	return ip, nil
+	return entries[0].clone(), nil
 }
 
-func (c *MemoryCache) Save(ip net.IP) error {
+func (c *MemoryCache) Save(family string, entry Entry) error {
 	c.mu.Lock()
 	defer c.mu.Unlock()
 	// Store a copy to prevent external modification
-	c.ip = make(net.IP, len(ip))
-	copy(c.ip, ip)
+	c.history[family] = prependEntry(c.history[family], entry.clone())
+	return nil
+}
+
+func (c *MemoryCache) History(family string, limit int) ([]Entry, error) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return copyHistory(c.history[family], limit), nil
+}
+
+func (c *MemoryCache) State(key string) ([]byte, error) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return bytes.Clone(c.state[key]), nil
+}
+
+func (c *MemoryCache) SetState(key string, value []byte) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if value == nil {
+		delete(c.state, key)
+		return nil
+	}
+	c.state[key] = bytes.Clone(value)
 	return nil
 }
+
+func (c *MemoryCache) Close() error {
+	return nil
+}
+
+// prependEntry adds entry as the newest history item, dropping the oldest
+// ones beyond historyLimit.
+func prependEntry(entries []*Entry, entry *Entry) []*Entry {
+	res := make([]*Entry, 0, len(entries)+1)
+	res = append(res, entry)
+	res = append(res, entries...)
+	if len(res) > historyLimit {
+		res = res[:historyLimit]
+	}
+	return res
+}
+
+func copyHistory(entries []*Entry, limit int) []Entry {
+	if limit <= 0 || limit > len(entries) {
+		limit = len(entries)
+	}
+	res := make([]Entry, 0, limit)
+	for _, entry := range entries[:limit] {
+		res = append(res, *entry.clone())
+	}
+	return res
+}
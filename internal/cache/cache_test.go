@@ -2,26 +2,152 @@ package cache
 
 import (
 	"net"
+	"os"
+	"path/filepath"
 	"testing"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
 )
 
 func TestMemoryCache(t *testing.T) {
 	c := NewMemoryCache()
 
-	if ip, err := c.Load(); err != nil || ip != nil {
-		t.Fatalf("expected empty cache, got %v %v", ip, err)
+	if entry, err := c.Load("ipv4"); err != nil || entry != nil {
+		t.Fatalf("expected empty cache, got %v %v", entry, err)
 	}
 
 	target := net.ParseIP("203.0.113.1")
-	if err := c.Save(target); err != nil {
+	if err := c.Save("ipv4", Entry{IP: target}); err != nil {
 		t.Fatalf("save failed: %v", err)
 	}
 
-	got, err := c.Load()
+	got, err := c.Load("ipv4")
 	if err != nil {
 		t.Fatalf("load failed: %v", err)
 	}
-	if !got.Equal(target) {
-		t.Fatalf("expected %s, got %s", target, got)
+	if !got.IP.Equal(target) {
+		t.Fatalf("expected %s, got %s", target, got.IP)
+	}
+
+	if entry, err := c.Load("ipv6"); err != nil || entry != nil {
+		t.Fatalf("expected families to be cached separately, got %v %v", entry, err)
+	}
+}
+
+func TestFileCachePersists(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "state", "last_ip")
+	c, err := NewFileCache(path)
+	if err != nil {
+		t.Fatalf("open failed: %v", err)
+	}
+
+	at := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	entry := Entry{IP: net.ParseIP("2001:db8::1"), UpdatedAt: at, Records: []string{"example.com @ AAAA"}}
+	if err := c.Save("ipv6", entry); err != nil {
+		t.Fatalf("save failed: %v", err)
+	}
+
+	reopened, err := NewFileCache(path)
+	if err != nil {
+		t.Fatalf("reopen failed: %v", err)
+	}
+	got, err := reopened.Load("ipv6")
+	if err != nil || got == nil {
+		t.Fatalf("load failed: %v %v", got, err)
+	}
+	if !got.IP.Equal(entry.IP) || !got.UpdatedAt.Equal(at) || len(got.Records) != 1 || got.Records[0] != entry.Records[0] {
+		t.Fatalf("unexpected entry after reopen: %+v", got)
+	}
+	if v4, _ := reopened.Load("ipv4"); v4 != nil {
+		t.Fatalf("expected no ipv4 entry, got %+v", v4)
+	}
+
+	matches, _ := filepath.Glob(path + ".tmp-*")
+	if len(matches) != 0 {
+		t.Fatalf("temporary files left behind: %v", matches)
+	}
+}
+
+func TestFileCacheRecoversCorruptFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "last_ip")
+	if err := os.WriteFile(path, []byte(`{"version":1,"families":`), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	c, err := NewFileCache(path)
+	if err != nil {
+		t.Fatalf("expected recovery, got %v", err)
+	}
+	if c.Recovered() != path+".corrupt" {
+		t.Fatalf("expected corrupt file to be moved aside, got %q", c.Recovered())
+	}
+	if entry, _ := c.Load("ipv4"); entry != nil {
+		t.Fatalf("expected empty cache after recovery, got %+v", entry)
+	}
+	if err := c.Save("ipv4", Entry{IP: net.ParseIP("203.0.113.1")}); err != nil {
+		t.Fatalf("save after recovery failed: %v", err)
+	}
+}
+
+func TestFileCacheReadsLegacyFormat(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "last_ip")
+	if err := os.WriteFile(path, []byte("198.51.100.4\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	c, err := NewFileCache(path)
+	if err != nil {
+		t.Fatalf("open failed: %v", err)
+	}
+	entry, _ := c.Load("ipv4")
+	if entry == nil || !entry.IP.Equal(net.ParseIP("198.51.100.4")) {
+		t.Fatalf("expected legacy IP to be loaded, got %+v", entry)
+	}
+}
+
+func TestKVCacheLocksFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "state.db")
+	c, err := NewKVCache(path)
+	if err != nil {
+		t.Fatalf("open failed: %v", err)
+	}
+	defer c.Close()
+	if second, err := NewKVCache(path); err == nil {
+		second.Close()
+		t.Fatalf("expected second open to fail while locked")
+	}
+}
+
+func TestKVCacheAppendsAfterCorruptLatest(t *testing.T) {
+	c, err := NewKVCache(filepath.Join(t.TempDir(), "state.db"))
+	if err != nil {
+		t.Fatalf("open failed: %v", err)
+	}
+	defer c.Close()
+	for _, ip := range []string{"192.0.2.1", "192.0.2.2"} {
+		if err := c.Save("ipv4", Entry{IP: net.ParseIP(ip), UpdatedAt: time.Now()}); err != nil {
+			t.Fatalf("save failed: %v", err)
+		}
+	}
+	if err := c.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(kvBucketLatest).Put([]byte("ipv4"), []byte("{"))
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := c.Save("ipv4", Entry{IP: net.ParseIP("192.0.2.3"), UpdatedAt: time.Now()}); err != nil {
+		t.Fatalf("save failed: %v", err)
+	}
+	history, err := c.History("ipv4", 0)
+	if err != nil {
+		t.Fatalf("history failed: %v", err)
+	}
+	var got []string
+	for _, e := range history {
+		got = append(got, e.IP.String())
+	}
+	if len(got) != 3 || got[0] != "192.0.2.3" || got[2] != "192.0.2.1" {
+		t.Fatalf("expected the new entry after the old ones, got %v", got)
 	}
 }
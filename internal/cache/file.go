@@ -0,0 +1,234 @@
+package cache
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+const fileFormatVersion = 2
+
+// FileCache stores IP state in a JSON file so it survives restarts. Writes go
+// to a temporary file that is fsynced and renamed over the original, so a
+// crash never leaves a half-written cache behind.
+type FileCache struct {
+	mu        sync.Mutex
+	path      string
+	history   map[string][]*Entry
+	state     map[string][]byte
+	recovered string
+}
+
+type fileState struct {
+	Version  int                   `json:"version"`
+	Families map[string]fileFamily `json:"families"`
+	State    map[string][]byte     `json:"state,omitempty"`
+}
+
+// fileFamily holds the latest entry inline, followed by older ones.
+type fileFamily struct {
+	fileEntry
+	History []fileEntry `json:"history,omitempty"`
+}
+
+type fileEntry struct {
+	IP        string    `json:"ip"`
+	UpdatedAt time.Time `json:"updated_at"`
+	Records   []string  `json:"records,omitempty"`
+}
+
+// NewFileCache opens the cache stored at path. A missing file yields an empty
+// cache. A file that cannot be parsed is moved aside (see Recovered) and the
+// cache starts empty, so the next sync rewrites the records once.
+func NewFileCache(path string) (*FileCache, error) {
+	c := &FileCache{path: path, history: make(map[string][]*Entry), state: make(map[string][]byte)}
+
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return c, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("read cache %s: %w", path, err)
+	}
+
+	if err := c.decode(data); err != nil {
+		quarantine := path + ".corrupt"
+		if err := os.Rename(path, quarantine); err != nil {
+			return nil, fmt.Errorf("move aside corrupt cache %s: %w", path, err)
+		}
+		c.history = make(map[string][]*Entry)
+		c.state = make(map[string][]byte)
+		c.recovered = quarantine
+	}
+	return c, nil
+}
+
+// Recovered returns the path the corrupt cache file was moved to when opening
+// the cache, or an empty string if the file was read successfully.
+func (c *FileCache) Recovered() string {
+	return c.recovered
+}
+
+// Load returns the entry for family, or nil if nothing was saved yet.
+func (c *FileCache) Load(family string) (*Entry, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	entries := c.history[family]
+	if len(entries) == 0 {
+		return nil, nil
+	}
+	return entries[0].clone(), nil
+}
+
+func (c *FileCache) Save(family string, entry Entry) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	history := make(map[string][]*Entry, len(c.history)+1)
+	for k, v := range c.history {
+		history[k] = v
+	}
+	history[family] = prependEntry(history[family], entry.clone())
+	return c.commit(history, c.state)
+}
+
+func (c *FileCache) History(family string, limit int) ([]Entry, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return copyHistory(c.history[family], limit), nil
+}
+
+func (c *FileCache) State(key string) ([]byte, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return bytes.Clone(c.state[key]), nil
+}
+
+func (c *FileCache) SetState(key string, value []byte) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	state := make(map[string][]byte, len(c.state)+1)
+	for k, v := range c.state {
+		state[k] = v
+	}
+	if value == nil {
+		delete(state, key)
+	} else {
+		state[key] = bytes.Clone(value)
+	}
+	return c.commit(c.history, state)
+}
+
+func (c *FileCache) Close() error {
+	return nil
+}
+
+// commit writes the given state to disk and only then makes it current.
+func (c *FileCache) commit(history map[string][]*Entry, state map[string][]byte) error {
+	if err := writeFileAtomic(c.path, encodeFile(history, state)); err != nil {
+		return err
+	}
+	c.history = history
+	c.state = state
+	return nil
+}
+
+func encodeFile(history map[string][]*Entry, state map[string][]byte) []byte {
+	fs := fileState{Version: fileFormatVersion, Families: make(map[string]fileFamily, len(history))}
+	for family, entries := range history {
+		if len(entries) == 0 {
+			continue
+		}
+		ff := fileFamily{fileEntry: toFileEntry(entries[0])}
+		for _, entry := range entries[1:] {
+			ff.History = append(ff.History, toFileEntry(entry))
+		}
+		fs.Families[family] = ff
+	}
+	if len(state) > 0 {
+		fs.State = state
+	}
+	data, _ := json.MarshalIndent(fs, "", "  ")
+	return append(data, '\n')
+}
+
+func toFileEntry(entry *Entry) fileEntry {
+	return fileEntry{IP: entry.IP.String(), UpdatedAt: entry.UpdatedAt.UTC(), Records: entry.Records}
+}
+
+func (c *FileCache) decode(data []byte) error {
+	// Older releases stored a bare IP address.
+	if ip := net.ParseIP(string(bytes.TrimSpace(data))); ip != nil {
+		family := "ipv6"
+		if ip.To4() != nil {
+			family = "ipv4"
+		}
+		c.history[family] = []*Entry{{IP: ip}}
+		return nil
+	}
+
+	var fs fileState
+	if err := json.Unmarshal(data, &fs); err != nil {
+		return err
+	}
+	// Version 1 files have the same layout without history or state.
+	if fs.Version != 1 && fs.Version != fileFormatVersion {
+		return fmt.Errorf("unsupported cache version %d", fs.Version)
+	}
+	for family, ff := range fs.Families {
+		for _, fe := range append([]fileEntry{ff.fileEntry}, ff.History...) {
+			ip := net.ParseIP(fe.IP)
+			if ip == nil {
+				return fmt.Errorf("invalid IP %q for %s", fe.IP, family)
+			}
+			c.history[family] = append(c.history[family], &Entry{IP: ip, UpdatedAt: fe.UpdatedAt, Records: fe.Records})
+		}
+	}
+	for k, v := range fs.State {
+		c.state[k] = v
+	}
+	return nil
+}
+
+// writeFileAtomic replaces path with data via write, fsync and rename.
+func writeFileAtomic(path string, data []byte) error {
+	dir := filepath.Dir(path)
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(dir, filepath.Base(path)+".tmp-*")
+	if err != nil {
+		return err
+	}
+	tmpName := tmp.Name()
+	defer os.Remove(tmpName) // no-op once renamed
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmpName, path); err != nil {
+		return err
+	}
+
+	// Persist the rename itself.
+	if d, err := os.Open(dir); err == nil {
+		_ = d.Sync()
+		d.Close()
+	}
+	return nil
+}
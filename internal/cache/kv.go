@@ -0,0 +1,190 @@
+package cache
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+var (
+	kvBucketLatest  = []byte("latest")
+	kvBucketState   = []byte("state")
+	kvHistoryPrefix = "history/"
+)
+
+// kvLockTimeout bounds how long NewKVCache waits for another process to
+// release the database.
+const kvLockTimeout = time.Second
+
+// KVCache stores IP state in a bbolt database file. Each family keeps its
+// latest entry plus a history bucket keyed by a zero-padded sequence number.
+type KVCache struct {
+	db *bolt.DB
+}
+
+type kvEntry struct {
+	IP        string    `json:"ip"`
+	UpdatedAt time.Time `json:"updated_at"`
+	Records   []string  `json:"records,omitempty"`
+	Seq       uint64    `json:"seq"`
+}
+
+// NewKVCache opens or creates the database at path. The file stays locked
+// until Close.
+func NewKVCache(path string) (*KVCache, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return nil, err
+	}
+	db, err := bolt.Open(path, 0o600, &bolt.Options{Timeout: kvLockTimeout})
+	if err != nil {
+		return nil, fmt.Errorf("open kv cache %s: %w", path, err)
+	}
+	return &KVCache{db: db}, nil
+}
+
+// Load returns the entry for family, or nil if nothing was saved yet.
+func (c *KVCache) Load(family string) (*Entry, error) {
+	var entry *Entry
+	err := c.db.View(func(tx *bolt.Tx) error {
+		data := get(tx, kvBucketLatest, []byte(family))
+		if data == nil {
+			return nil
+		}
+		var err error
+		entry, err = decodeKVEntry(data)
+		return err
+	})
+	return entry, err
+}
+
+func (c *KVCache) Save(family string, entry Entry) error {
+	return c.db.Update(func(tx *bolt.Tx) error {
+		latest, err := tx.CreateBucketIfNotExists(kvBucketLatest)
+		if err != nil {
+			return err
+		}
+		history, err := tx.CreateBucketIfNotExists([]byte(kvHistoryPrefix + family))
+		if err != nil {
+			return err
+		}
+
+		// The next sequence follows the highest history key, so an entry
+		// that no longer decodes cannot make new ones overwrite old ones.
+		var seq uint64
+		if k, _ := history.Cursor().Last(); k != nil {
+			prev, err := strconv.ParseUint(string(k), 10, 64)
+			if err != nil {
+				return fmt.Errorf("invalid history key %q: %w", k, err)
+			}
+			seq = prev + 1
+		}
+		data, err := json.Marshal(kvEntry{
+			IP:        entry.IP.String(),
+			UpdatedAt: entry.UpdatedAt.UTC(),
+			Records:   entry.Records,
+			Seq:       seq,
+		})
+		if err != nil {
+			return err
+		}
+		if err := latest.Put([]byte(family), data); err != nil {
+			return err
+		}
+		if err := history.Put(kvSeqKey(seq), data); err != nil {
+			return err
+		}
+
+		// Keys sort by sequence, so the oldest come first.
+		cur := history.Cursor()
+		n := 0
+		for k, _ := cur.First(); k != nil; k, _ = cur.Next() {
+			n++
+		}
+		for k, _ := cur.First(); k != nil && n > historyLimit; k, _ = cur.First() {
+			if err := history.Delete(k); err != nil {
+				return err
+			}
+			n--
+		}
+		return nil
+	})
+}
+
+func (c *KVCache) History(family string, limit int) ([]Entry, error) {
+	var res []Entry
+	err := c.db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(kvHistoryPrefix + family))
+		if b == nil {
+			return nil
+		}
+		cur := b.Cursor()
+		for k, data := cur.Last(); k != nil && (limit <= 0 || len(res) < limit); k, data = cur.Prev() {
+			entry, err := decodeKVEntry(data)
+			if err != nil {
+				return err
+			}
+			res = append(res, *entry)
+		}
+		return nil
+	})
+	return res, err
+}
+
+func (c *KVCache) State(key string) ([]byte, error) {
+	var value []byte
+	err := c.db.View(func(tx *bolt.Tx) error {
+		value = get(tx, kvBucketState, []byte(key))
+		return nil
+	})
+	return value, err
+}
+
+func (c *KVCache) SetState(key string, value []byte) error {
+	return c.db.Update(func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucketIfNotExists(kvBucketState)
+		if err != nil {
+			return err
+		}
+		if value == nil {
+			return b.Delete([]byte(key))
+		}
+		return b.Put([]byte(key), value)
+	})
+}
+
+func (c *KVCache) Close() error {
+	return c.db.Close()
+}
+
+// get returns a copy of the value of key in bucket, which bbolt only keeps
+// valid for the life of the transaction.
+func get(tx *bolt.Tx, bucket, key []byte) []byte {
+	b := tx.Bucket(bucket)
+	if b == nil {
+		return nil
+	}
+	return bytes.Clone(b.Get(key))
+}
+
+func kvSeqKey(seq uint64) []byte {
+	return []byte(fmt.Sprintf("%020d", seq))
+}
+
+func decodeKVEntry(data []byte) (*Entry, error) {
+	var stored kvEntry
+	if err := json.Unmarshal(data, &stored); err != nil {
+		return nil, fmt.Errorf("decode cache entry: %w", err)
+	}
+	ip := net.ParseIP(stored.IP)
+	if ip == nil {
+		return nil, fmt.Errorf("invalid cached IP %q", stored.IP)
+	}
+	return &Entry{IP: ip, UpdatedAt: stored.UpdatedAt, Records: stored.Records}, nil
+}
@@ -0,0 +1,164 @@
+package cache
+
+import (
+	"fmt"
+	"net"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// storeFactory opens a store. Calling it again with the same t must reopen the
+// same underlying data for durable backends.
+type storeFactory func(t *testing.T) Store
+
+func TestMemoryStoreConformance(t *testing.T) {
+	testStore(t, func(t *testing.T) Store { return NewMemoryCache() }, false)
+}
+
+func TestFileStoreConformance(t *testing.T) {
+	testStore(t, pathStore(func(path string) (Store, error) { return NewFileCache(path) }), true)
+}
+
+func TestKVStoreConformance(t *testing.T) {
+	testStore(t, pathStore(func(path string) (Store, error) { return NewKVCache(path) }), true)
+}
+
+func pathStore(open func(path string) (Store, error)) storeFactory {
+	paths := make(map[*testing.T]string)
+	return func(t *testing.T) Store {
+		t.Helper()
+		path, ok := paths[t]
+		if !ok {
+			path = filepath.Join(t.TempDir(), "state")
+			paths[t] = path
+		}
+		s, err := open(path)
+		if err != nil {
+			t.Fatalf("open store: %v", err)
+		}
+		return s
+	}
+}
+
+// testStore is the conformance suite every Store backend must pass.
+func testStore(t *testing.T, open storeFactory, durable bool) {
+	t.Run("Empty", func(t *testing.T) {
+		s := open(t)
+		defer s.Close()
+		if entry, err := s.Load("ipv4"); err != nil || entry != nil {
+			t.Fatalf("expected empty store, got %v %v", entry, err)
+		}
+		if history, err := s.History("ipv4", 0); err != nil || len(history) != 0 {
+			t.Fatalf("expected empty history, got %v %v", history, err)
+		}
+		if value, err := s.State("missing"); err != nil || value != nil {
+			t.Fatalf("expected unset state, got %q %v", value, err)
+		}
+	})
+
+	t.Run("SaveLoad", func(t *testing.T) {
+		s := open(t)
+		defer s.Close()
+		at := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+		entry := Entry{IP: net.ParseIP("203.0.113.1"), UpdatedAt: at, Records: []string{"example.com @ A"}}
+		if err := s.Save("ipv4", entry); err != nil {
+			t.Fatalf("save failed: %v", err)
+		}
+		entry.Records[0] = "mutated"
+
+		got, err := s.Load("ipv4")
+		if err != nil || got == nil {
+			t.Fatalf("load failed: %v %v", got, err)
+		}
+		if !got.IP.Equal(entry.IP) || !got.UpdatedAt.Equal(at) || len(got.Records) != 1 || got.Records[0] != "example.com @ A" {
+			t.Fatalf("unexpected entry: %+v", got)
+		}
+		if other, _ := s.Load("ipv6"); other != nil {
+			t.Fatalf("expected families to be stored separately, got %+v", other)
+		}
+	})
+
+	t.Run("History", func(t *testing.T) {
+		s := open(t)
+		defer s.Close()
+		for i := 0; i < historyLimit+5; i++ {
+			ip := net.ParseIP(fmt.Sprintf("2001:db8::%x", i+1))
+			if err := s.Save("ipv6", Entry{IP: ip}); err != nil {
+				t.Fatalf("save %d failed: %v", i, err)
+			}
+		}
+
+		latest, _ := s.Load("ipv6")
+		last := net.ParseIP(fmt.Sprintf("2001:db8::%x", historyLimit+5))
+		if latest == nil || !latest.IP.Equal(last) {
+			t.Fatalf("expected latest entry %s, got %+v", last, latest)
+		}
+
+		history, err := s.History("ipv6", 3)
+		if err != nil || len(history) != 3 {
+			t.Fatalf("expected 3 entries, got %v %v", history, err)
+		}
+		if !history[0].IP.Equal(last) || !history[2].IP.Equal(net.ParseIP(fmt.Sprintf("2001:db8::%x", historyLimit+3))) {
+			t.Fatalf("expected newest first, got %v", history)
+		}
+
+		all, _ := s.History("ipv6", 0)
+		if len(all) != historyLimit {
+			t.Fatalf("expected history to be capped at %d, got %d", historyLimit, len(all))
+		}
+	})
+
+	t.Run("State", func(t *testing.T) {
+		s := open(t)
+		defer s.Close()
+		if err := s.SetState("k", []byte("v1")); err != nil {
+			t.Fatalf("set failed: %v", err)
+		}
+		if err := s.SetState("k", []byte("v2")); err != nil {
+			t.Fatalf("set failed: %v", err)
+		}
+		if value, _ := s.State("k"); string(value) != "v2" {
+			t.Fatalf("expected v2, got %q", value)
+		}
+		if err := s.SetState("k", nil); err != nil {
+			t.Fatalf("delete failed: %v", err)
+		}
+		if value, _ := s.State("k"); value != nil {
+			t.Fatalf("expected key to be removed, got %q", value)
+		}
+	})
+
+	if !durable {
+		return
+	}
+
+	t.Run("Reopen", func(t *testing.T) {
+		s := open(t)
+		if err := s.Save("ipv4", Entry{IP: net.ParseIP("198.51.100.1")}); err != nil {
+			t.Fatalf("save failed: %v", err)
+		}
+		if err := s.Save("ipv4", Entry{IP: net.ParseIP("198.51.100.2")}); err != nil {
+			t.Fatalf("save failed: %v", err)
+		}
+		if err := s.SetState("k", []byte("v")); err != nil {
+			t.Fatalf("set failed: %v", err)
+		}
+		if err := s.Close(); err != nil {
+			t.Fatalf("close failed: %v", err)
+		}
+
+		s = open(t)
+		defer s.Close()
+		latest, _ := s.Load("ipv4")
+		if latest == nil || !latest.IP.Equal(net.ParseIP("198.51.100.2")) {
+			t.Fatalf("expected latest entry to persist, got %+v", latest)
+		}
+		if history, _ := s.History("ipv4", 0); len(history) != 2 {
+			t.Fatalf("expected history to persist, got %v", history)
+		}
+		if value, _ := s.State("k"); string(value) != "v" {
+			t.Fatalf("expected state to persist, got %q", value)
+		}
+	})
+}
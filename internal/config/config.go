@@ -1,11 +1,20 @@
 package config
 
 import (
+	"bytes"
+	"encoding/base64"
+	"errors"
 	"fmt"
+	"io"
 	"os"
+	"slices"
 	"strconv"
 	"strings"
 	"time"
+
+	"github.com/erkki/dnsupdater/internal/ipcheck"
+	"github.com/erkki/dnsupdater/internal/rules"
+	"gopkg.in/yaml.v3"
 )
 
 const (
@@ -15,26 +24,86 @@ const (
 
 // Config holds runtime configuration for the updater.
 type Config struct {
+	Provider         string
 	APIKey           string
 	APISecret        string
 	BaseURL          string
+	RFC2136          RFC2136Config
 	PollInterval     time.Duration
+	RefreshInterval  time.Duration
+	RetryInterval    time.Duration
+	MaxAttempts      int
+	ListRate         float64
+	WriteRate        float64
+	FetchWorkers     int
+	FetchPartial     bool
+	IPFamilies       []ipcheck.Family
 	IPCheckEndpoints []string
+	IPv6Endpoints    []string
+	// IPEndpointDefs, from the config file, replace IPCheckEndpoints and
+	// IPv6Endpoints when set.
+	IPEndpointDefs   []ipcheck.Endpoint
+	IPDetection      string
+	IPQuorum         int
+	IPRaceStagger    time.Duration
 	DryRun           bool
+	UpdateStrategy   string
+	CacheBackend     string
+	CachePath        string
 	MockIP           string
+	MockIPv6         string
+	RecordInclude    []rules.Rule
+	RecordExclude    []rules.Rule
+	DesiredStateFile string
+}
+
+// File is the layout of the optional YAML file named by CONFIG_FILE.
+type File struct {
+	Records struct {
+		Include []rules.Rule `yaml:"include"`
+		Exclude []rules.Rule `yaml:"exclude"`
+	} `yaml:"records"`
+	IP struct {
+		Endpoints []ipcheck.Endpoint `yaml:"endpoints"`
+	} `yaml:"ip"`
+}
+
+// RFC2136Config holds the settings of the rfc2136 provider.
+type RFC2136Config struct {
+	Server       string
+	Zones        []string
+	KeyName      string
+	KeySecret    []byte
+	KeyAlgorithm string
+	Transport    string
+	Names        []string
 }
 
 // Load reads configuration from environment variables with sane defaults.
 func Load() (Config, error) {
 	cfg := Config{
+		Provider:         strings.ToLower(getEnv("DNS_PROVIDER", "spaceship")),
 		BaseURL:          getEnv("SPACESHIP_BASE_URL", defaultBaseURL),
+		IPFamilies:       []ipcheck.Family{ipcheck.IPv4},
 		IPCheckEndpoints: defaultIPEndpoints(),
+		IPv6Endpoints:    defaultIPv6Endpoints(),
 	}
 
-	cfg.APIKey = os.Getenv("SPACESHIP_API_KEY")
-	cfg.APISecret = os.Getenv("SPACESHIP_API_SECRET")
-	if cfg.APIKey == "" || cfg.APISecret == "" {
-		return Config{}, fmt.Errorf("SPACESHIP_API_KEY and SPACESHIP_API_SECRET must be set")
+	switch cfg.Provider {
+	case "spaceship":
+		cfg.APIKey = os.Getenv("SPACESHIP_API_KEY")
+		cfg.APISecret = os.Getenv("SPACESHIP_API_SECRET")
+		if cfg.APIKey == "" || cfg.APISecret == "" {
+			return Config{}, fmt.Errorf("SPACESHIP_API_KEY and SPACESHIP_API_SECRET must be set")
+		}
+	case "rfc2136":
+		rc, err := loadRFC2136()
+		if err != nil {
+			return Config{}, err
+		}
+		cfg.RFC2136 = rc
+	default:
+		return Config{}, fmt.Errorf("invalid DNS_PROVIDER: %s", cfg.Provider)
 	}
 
 	pollStr := getEnv("POLL_INTERVAL_HOURS", "24")
@@ -44,17 +113,181 @@ func Load() (Config, error) {
 	}
 	cfg.PollInterval = time.Duration(hrs) * time.Hour
 
+	if v := os.Getenv("RECORD_REFRESH_MINUTES"); v != "" {
+		mins, err := strconv.Atoi(v)
+		if err != nil || mins < 0 {
+			return Config{}, fmt.Errorf("invalid RECORD_REFRESH_MINUTES: %s", v)
+		}
+		cfg.RefreshInterval = time.Duration(mins) * time.Minute
+	}
+
+	retryStr := getEnv("SYNC_RETRY_MINUTES", "5")
+	retryMins, err := strconv.Atoi(retryStr)
+	if err != nil || retryMins < 0 {
+		return Config{}, fmt.Errorf("invalid SYNC_RETRY_MINUTES: %s", retryStr)
+	}
+	cfg.RetryInterval = time.Duration(retryMins) * time.Minute
+
+	attemptsStr := getEnv("API_MAX_ATTEMPTS", "4")
+	cfg.MaxAttempts, err = strconv.Atoi(attemptsStr)
+	if err != nil || cfg.MaxAttempts < 1 {
+		return Config{}, fmt.Errorf("invalid API_MAX_ATTEMPTS: %s", attemptsStr)
+	}
+
+	for _, r := range []struct {
+		env      string
+		fallback string
+		dst      *float64
+	}{
+		{"API_LIST_RATE", "5", &cfg.ListRate},
+		{"API_WRITE_RATE", "2", &cfg.WriteRate},
+	} {
+		v := getEnv(r.env, r.fallback)
+		rate, err := strconv.ParseFloat(v, 64)
+		if err != nil || rate < 0 {
+			return Config{}, fmt.Errorf("invalid %s: %s", r.env, v)
+		}
+		*r.dst = rate
+	}
+
+	workersStr := getEnv("API_FETCH_WORKERS", "4")
+	cfg.FetchWorkers, err = strconv.Atoi(workersStr)
+	if err != nil || cfg.FetchWorkers < 1 {
+		return Config{}, fmt.Errorf("invalid API_FETCH_WORKERS: %s", workersStr)
+	}
+	cfg.FetchPartial = strings.EqualFold(os.Getenv("API_FETCH_PARTIAL"), "true")
+
+	if v := os.Getenv("IP_FAMILIES"); v != "" {
+		cfg.IPFamilies = nil
+		for _, name := range parseList(v) {
+			family, err := ipcheck.ParseFamily(name)
+			if err != nil {
+				return Config{}, fmt.Errorf("invalid IP_FAMILIES entry: %w", err)
+			}
+			if !slices.Contains(cfg.IPFamilies, family) {
+				cfg.IPFamilies = append(cfg.IPFamilies, family)
+			}
+		}
+	}
+
 	if v := os.Getenv("IP_ENDPOINTS"); v != "" {
 		cfg.IPCheckEndpoints = parseList(v)
 	}
+	if v := os.Getenv("IPV6_ENDPOINTS"); v != "" {
+		cfg.IPv6Endpoints = parseList(v)
+	}
+
+	cfg.IPDetection = strings.ToLower(getEnv("IP_DETECTION", "first"))
+	quorumStr := getEnv("IP_QUORUM", "0")
+	cfg.IPQuorum, err = strconv.Atoi(quorumStr)
+	if err != nil || cfg.IPQuorum < 0 {
+		return Config{}, fmt.Errorf("invalid IP_QUORUM: %s", quorumStr)
+	}
+
+	staggerStr := getEnv("IP_RACE_STAGGER_MS", "250")
+	staggerMs, err := strconv.Atoi(staggerStr)
+	if err != nil || staggerMs < 0 {
+		return Config{}, fmt.Errorf("invalid IP_RACE_STAGGER_MS: %s", staggerStr)
+	}
+	cfg.IPRaceStagger = time.Duration(staggerMs) * time.Millisecond
 
 	cfg.DryRun = strings.EqualFold(os.Getenv("DRY_RUN"), "true")
 
+	cfg.UpdateStrategy = strings.ToLower(getEnv("UPDATE_STRATEGY", "upsert"))
+	if cfg.UpdateStrategy != "upsert" && cfg.UpdateStrategy != "replace" {
+		return Config{}, fmt.Errorf("invalid UPDATE_STRATEGY: %s", cfg.UpdateStrategy)
+	}
+
+	cfg.CachePath = os.Getenv("CACHE_PATH")
+	defaultBackend := "memory"
+	if cfg.CachePath != "" {
+		defaultBackend = "file"
+	}
+	cfg.CacheBackend = strings.ToLower(getEnv("CACHE_BACKEND", defaultBackend))
+	switch cfg.CacheBackend {
+	case "memory":
+	case "file", "kv":
+		if cfg.CachePath == "" {
+			return Config{}, fmt.Errorf("CACHE_BACKEND=%s requires CACHE_PATH", cfg.CacheBackend)
+		}
+	default:
+		return Config{}, fmt.Errorf("invalid CACHE_BACKEND: %s", cfg.CacheBackend)
+	}
+
 	cfg.MockIP = os.Getenv("MOCK_IP")
+	cfg.MockIPv6 = os.Getenv("MOCK_IPV6")
+
+	if path := os.Getenv("CONFIG_FILE"); path != "" {
+		file, err := LoadFile(path)
+		if err != nil {
+			return Config{}, err
+		}
+		cfg.RecordInclude = file.Records.Include
+		cfg.RecordExclude = file.Records.Exclude
+		for i := range file.IP.Endpoints {
+			if err := file.IP.Endpoints[i].Validate(); err != nil {
+				return Config{}, fmt.Errorf("config file ip.endpoints[%d]: %w", i, err)
+			}
+		}
+		cfg.IPEndpointDefs = file.IP.Endpoints
+	}
+	include, err := rules.Parse(os.Getenv("RECORD_INCLUDE"))
+	if err != nil {
+		return Config{}, fmt.Errorf("invalid RECORD_INCLUDE: %w", err)
+	}
+	exclude, err := rules.Parse(os.Getenv("RECORD_EXCLUDE"))
+	if err != nil {
+		return Config{}, fmt.Errorf("invalid RECORD_EXCLUDE: %w", err)
+	}
+	cfg.RecordInclude = append(cfg.RecordInclude, include...)
+	cfg.RecordExclude = append(cfg.RecordExclude, exclude...)
+
+	cfg.DesiredStateFile = os.Getenv("DESIRED_STATE_FILE")
 
 	return cfg, nil
 }
 
+// LoadFile reads the YAML configuration file at path. Unknown keys are
+// rejected so typos surface as errors.
+func LoadFile(path string) (File, error) {
+	var file File
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return file, fmt.Errorf("read config file: %w", err)
+	}
+	dec := yaml.NewDecoder(bytes.NewReader(data))
+	dec.KnownFields(true)
+	if err := dec.Decode(&file); err != nil && !errors.Is(err, io.EOF) {
+		return file, fmt.Errorf("parse config file %s: %w", path, err)
+	}
+	return file, nil
+}
+
+func loadRFC2136() (RFC2136Config, error) {
+	rc := RFC2136Config{
+		Server:       os.Getenv("RFC2136_SERVER"),
+		Zones:        parseList(os.Getenv("RFC2136_ZONES")),
+		KeyName:      os.Getenv("RFC2136_TSIG_KEY"),
+		KeyAlgorithm: getEnv("RFC2136_TSIG_ALGORITHM", "hmac-sha256"),
+		Transport:    strings.ToLower(getEnv("RFC2136_TRANSPORT", "udp")),
+		Names:        parseList(os.Getenv("RFC2136_NAMES")),
+	}
+	if rc.Server == "" || len(rc.Zones) == 0 {
+		return RFC2136Config{}, fmt.Errorf("RFC2136_SERVER and RFC2136_ZONES must be set")
+	}
+	if rc.Transport != "udp" && rc.Transport != "tcp" {
+		return RFC2136Config{}, fmt.Errorf("invalid RFC2136_TRANSPORT: %s", rc.Transport)
+	}
+	if rc.KeyName != "" {
+		secret, err := base64.StdEncoding.DecodeString(os.Getenv("RFC2136_TSIG_SECRET"))
+		if err != nil || len(secret) == 0 {
+			return RFC2136Config{}, fmt.Errorf("RFC2136_TSIG_SECRET must be a base64 encoded key when RFC2136_TSIG_KEY is set")
+		}
+		rc.KeySecret = secret
+	}
+	return rc, nil
+}
+
 func getEnv(key, fallback string) string {
 	if val := os.Getenv(key); val != "" {
 		return val
@@ -81,3 +314,11 @@ func defaultIPEndpoints() []string {
 		"https://checkip.amazonaws.com",
 	}
 }
+
+func defaultIPv6Endpoints() []string {
+	return []string{
+		"https://api6.ipify.org",
+		"https://ifconfig.co",
+		"https://v6.ident.me",
+	}
+}
@@ -0,0 +1,228 @@
+// Package desired describes the DNS records the updater owns, as declared in
+// a YAML state file, and computes the changes that bring live records in line
+// with them.
+package desired
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"sort"
+	"strings"
+	"text/template"
+
+	"github.com/erkki/dnsupdater/internal/ipcheck"
+	"github.com/erkki/dnsupdater/internal/provider"
+	"gopkg.in/yaml.v3"
+)
+
+// DefaultTTL is used for records that declare no TTL when the file sets no
+// default either.
+const DefaultTTL = 3600
+
+// Source tells where the value of a declared record comes from.
+type Source string
+
+const (
+	// SourcePublicIPv4 uses the detected public IPv4 address.
+	SourcePublicIPv4 Source = "public-ipv4"
+	// SourcePublicIPv6 uses the detected public IPv6 address.
+	SourcePublicIPv6 Source = "public-ipv6"
+	// SourceStatic uses Value as is.
+	SourceStatic Source = "static"
+	// SourceTemplate renders Value as a text/template with the fields
+	// .IPv4, .IPv6, .Domain and .Name.
+	SourceTemplate Source = "template"
+)
+
+// RecordSpec declares one managed record. Several specs with the same domain,
+// name and type form a record set.
+type RecordSpec struct {
+	Domain string `yaml:"domain"`
+	Name   string `yaml:"name"`
+	Type   string `yaml:"type"`
+	TTL    int    `yaml:"ttl"`
+	Source Source `yaml:"source"`
+	Value  string `yaml:"value"`
+
+	tmpl *template.Template
+}
+
+// State is the content of a desired-state file.
+type State struct {
+	// TTL is the default TTL of records that declare none.
+	TTL int `yaml:"ttl"`
+	// Prune deletes live records in the declared domains that no spec
+	// covers.
+	Prune   bool         `yaml:"prune"`
+	Records []RecordSpec `yaml:"records"`
+}
+
+// Load reads and validates the desired-state file at path.
+func Load(path string) (*State, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("read desired state: %w", err)
+	}
+	s, err := Parse(data)
+	if err != nil {
+		return nil, fmt.Errorf("desired state %s: %w", path, err)
+	}
+	return s, nil
+}
+
+// Parse decodes and validates a desired-state document.
+func Parse(data []byte) (*State, error) {
+	s := &State{}
+	// Unknown keys are rejected so typos surface as errors; an empty
+	// document is an empty state.
+	dec := yaml.NewDecoder(bytes.NewReader(data))
+	dec.KnownFields(true)
+	if err := dec.Decode(s); err != nil && !errors.Is(err, io.EOF) {
+		return nil, err
+	}
+	if s.TTL == 0 {
+		s.TTL = DefaultTTL
+	}
+	for i := range s.Records {
+		if err := s.Records[i].normalize(s.TTL); err != nil {
+			return nil, fmt.Errorf("record %d: %w", i+1, err)
+		}
+	}
+	return s, nil
+}
+
+func (r *RecordSpec) normalize(defaultTTL int) error {
+	r.Domain = strings.TrimSuffix(strings.ToLower(strings.TrimSpace(r.Domain)), ".")
+	r.Name = strings.ToLower(strings.TrimSpace(r.Name))
+	r.Type = strings.ToUpper(strings.TrimSpace(r.Type))
+	if r.Domain == "" || r.Name == "" || r.Type == "" {
+		return fmt.Errorf("domain, name and type are required")
+	}
+	if r.TTL == 0 {
+		r.TTL = defaultTTL
+	}
+	if r.TTL < 0 {
+		return fmt.Errorf("%s: invalid ttl %d", r.Key(), r.TTL)
+	}
+	if r.Source == "" {
+		switch {
+		case r.Value != "":
+			r.Source = SourceStatic
+		case r.Type == "A":
+			r.Source = SourcePublicIPv4
+		case r.Type == "AAAA":
+			r.Source = SourcePublicIPv6
+		}
+	}
+	switch r.Source {
+	case SourcePublicIPv4, SourcePublicIPv6:
+		if family, _ := r.Family(); r.Type != family.RecordType() {
+			return fmt.Errorf("%s: source %s needs type %s", r.Key(), r.Source, family.RecordType())
+		}
+	case SourceStatic:
+		if r.Value == "" {
+			return fmt.Errorf("%s: static records need a value", r.Key())
+		}
+		if (r.Type == "A" || r.Type == "AAAA") && net.ParseIP(r.Value) == nil {
+			return fmt.Errorf("%s: invalid address %q", r.Key(), r.Value)
+		}
+	case SourceTemplate:
+		tmpl, err := template.New(r.Key().String()).Option("missingkey=error").Parse(r.Value)
+		if err != nil {
+			return fmt.Errorf("%s: %w", r.Key(), err)
+		}
+		r.tmpl = tmpl
+	default:
+		return fmt.Errorf("%s: unknown source %q", r.Key(), r.Source)
+	}
+	return nil
+}
+
+// Key identifies the record set the spec belongs to.
+func (r RecordSpec) Key() Key {
+	return Key{Domain: r.Domain, Name: r.Name, Type: r.Type}
+}
+
+// Family returns the address family the spec's value is taken from, if any.
+func (r RecordSpec) Family() (ipcheck.Family, bool) {
+	switch r.Source {
+	case SourcePublicIPv4:
+		return ipcheck.IPv4, true
+	case SourcePublicIPv6:
+		return ipcheck.IPv6, true
+	}
+	return "", false
+}
+
+// Domains returns the declared domains, sorted.
+func (s *State) Domains() []string {
+	seen := make(map[string]bool)
+	var res []string
+	for _, r := range s.Records {
+		if !seen[r.Domain] {
+			seen[r.Domain] = true
+			res = append(res, r.Domain)
+		}
+	}
+	sort.Strings(res)
+	return res
+}
+
+// Resolve computes the value of every declared record from the detected
+// public IPs. Record sets that depend on an address missing from ips are
+// returned as pending; callers must leave them untouched.
+func (s *State) Resolve(ips map[ipcheck.Family]net.IP) ([]provider.Record, map[Key]bool, error) {
+	pending := make(map[Key]bool)
+	var res []provider.Record
+	for _, spec := range s.Records {
+		value, ok, err := spec.resolve(ips)
+		if err != nil {
+			return nil, nil, err
+		}
+		if !ok {
+			pending[spec.Key()] = true
+			continue
+		}
+		res = append(res, provider.Record{Domain: spec.Domain, Name: spec.Name, Type: spec.Type, Content: value, TTL: spec.TTL})
+	}
+	// A set is only written as a whole.
+	kept := res[:0]
+	for _, record := range res {
+		if !pending[KeyOf(record)] {
+			kept = append(kept, record)
+		}
+	}
+	return kept, pending, nil
+}
+
+func (r RecordSpec) resolve(ips map[ipcheck.Family]net.IP) (string, bool, error) {
+	switch r.Source {
+	case SourcePublicIPv4, SourcePublicIPv6:
+		family, _ := r.Family()
+		ip := ips[family]
+		if ip == nil {
+			return "", false, nil
+		}
+		return ip.String(), true, nil
+	case SourceTemplate:
+		data := map[string]string{"Domain": r.Domain, "Name": r.Name}
+		if ip := ips[ipcheck.IPv4]; ip != nil {
+			data["IPv4"] = ip.String()
+		}
+		if ip := ips[ipcheck.IPv6]; ip != nil {
+			data["IPv6"] = ip.String()
+		}
+		var b strings.Builder
+		if err := r.tmpl.Execute(&b, data); err != nil {
+			// Only a missing address can fail here; the template was
+			// checked when the file was loaded.
+			return "", false, nil
+		}
+		return b.String(), true, nil
+	}
+	return r.Value, true, nil
+}
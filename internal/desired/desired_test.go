@@ -0,0 +1,177 @@
+package desired
+
+import (
+	"errors"
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/erkki/dnsupdater/internal/ipcheck"
+	"github.com/erkki/dnsupdater/internal/provider"
+)
+
+const testState = `
+ttl: 300
+prune: true
+records:
+  - domain: Example.com
+    name: "@"
+    type: A
+  - domain: example.com
+    name: "@"
+    type: AAAA
+  - domain: example.com
+    name: www
+    type: CNAME
+    value: example.com
+    ttl: 3600
+  - domain: example.com
+    name: "@"
+    type: TXT
+    source: template
+    value: "home={{.IPv4}}"
+`
+
+func TestParse(t *testing.T) {
+	s, err := Parse([]byte(testState))
+	if err != nil {
+		t.Fatalf("parse failed: %v", err)
+	}
+	if !s.Prune || len(s.Records) != 4 {
+		t.Fatalf("unexpected state: %+v", s)
+	}
+	first := s.Records[0]
+	if first.Domain != "example.com" || first.Source != SourcePublicIPv4 || first.TTL != 300 {
+		t.Fatalf("unexpected defaults: %+v", first)
+	}
+	if s.Records[1].Source != SourcePublicIPv6 || s.Records[2].Source != SourceStatic || s.Records[2].TTL != 3600 {
+		t.Fatalf("unexpected sources: %+v", s.Records)
+	}
+	if got := s.Domains(); len(got) != 1 || got[0] != "example.com" {
+		t.Fatalf("unexpected domains: %v", got)
+	}
+}
+
+func TestParseRejectsInvalidRecords(t *testing.T) {
+	cases := map[string]string{
+		"missing name":    "records:\n  - domain: example.com\n    type: A\n",
+		"family mismatch": "records:\n  - domain: example.com\n    name: home\n    type: AAAA\n    source: public-ipv4\n",
+		"static address":  "records:\n  - domain: example.com\n    name: home\n    type: A\n    value: not-an-ip\n",
+		"bad template":    "records:\n  - domain: example.com\n    name: home\n    type: TXT\n    source: template\n    value: \"{{.IPv4\"\n",
+		"unknown source":  "records:\n  - domain: example.com\n    name: home\n    type: TXT\n    source: dhcp\n",
+		"no value":        "records:\n  - domain: example.com\n    name: home\n    type: TXT\n",
+	}
+	for name, doc := range cases {
+		if _, err := Parse([]byte(doc)); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+}
+
+func TestResolve(t *testing.T) {
+	s, err := Parse([]byte(testState))
+	if err != nil {
+		t.Fatalf("parse failed: %v", err)
+	}
+	records, pending, err := s.Resolve(map[ipcheck.Family]net.IP{ipcheck.IPv4: net.ParseIP("203.0.113.7")})
+	if err != nil {
+		t.Fatalf("resolve failed: %v", err)
+	}
+	if !pending[Key{Domain: "example.com", Name: "@", Type: "AAAA"}] || len(pending) != 1 {
+		t.Fatalf("expected only the AAAA set to be pending, got %v", pending)
+	}
+	want := map[string]string{"A": "203.0.113.7", "CNAME": "example.com", "TXT": "home=203.0.113.7"}
+	if len(records) != len(want) {
+		t.Fatalf("unexpected records: %+v", records)
+	}
+	for _, r := range records {
+		if r.Content != want[r.Type] {
+			t.Fatalf("%s resolved to %q, want %q", r.Type, r.Content, want[r.Type])
+		}
+	}
+
+	// Without IPv4 the template cannot be rendered either.
+	_, pending, err = s.Resolve(map[ipcheck.Family]net.IP{ipcheck.IPv6: net.ParseIP("2001:db8::1")})
+	if err != nil {
+		t.Fatalf("resolve failed: %v", err)
+	}
+	if !pending[Key{Domain: "example.com", Name: "@", Type: "TXT"}] {
+		t.Fatalf("expected the template record to be pending, got %v", pending)
+	}
+}
+
+func TestDiff(t *testing.T) {
+	want := []provider.Record{
+		{Domain: "example.com", Name: "@", Type: "A", Content: "203.0.113.7", TTL: 300},
+		{Domain: "example.com", Name: "www", Type: "CNAME", Content: "example.com", TTL: 300},
+		{Domain: "example.com", Name: "mail", Type: "A", Content: "203.0.113.8", TTL: 300},
+		{Domain: "example.com", Name: "txt", Type: "TXT", Content: "a", TTL: 300},
+		{Domain: "example.com", Name: "txt", Type: "TXT", Content: "b", TTL: 300},
+	}
+	live := []provider.Record{
+		{Domain: "example.com", Name: "@", Type: "A", Content: "198.51.100.1", TTL: 300},
+		{Domain: "example.com", Name: "WWW", Type: "CNAME", Content: "Example.com.", TTL: 300},
+		{Domain: "example.com", Name: "txt", Type: "TXT", Content: "b", TTL: 300},
+		{Domain: "example.com", Name: "txt", Type: "TXT", Content: "a", TTL: 300},
+		{Domain: "example.com", Name: "old", Type: "A", Content: "198.51.100.2", TTL: 300},
+		{Domain: "example.com", Name: "vps", Type: "A", Content: "192.0.2.1", TTL: 300},
+		{Domain: "example.com", Name: "v6", Type: "AAAA", Content: "2001:db8::1", TTL: 300},
+	}
+	pending := map[Key]bool{{Domain: "example.com", Name: "v6", Type: "AAAA"}: true}
+	prune := func(r provider.Record) bool { return r.Name != "vps" }
+
+	changes := Diff(want, live, pending, prune)
+	var got []string
+	for _, c := range changes {
+		got = append(got, string(c.Action)+" "+c.Key.String())
+	}
+	expected := []string{
+		"update example.com @ A",
+		"create example.com mail A",
+		"delete example.com old A",
+	}
+	if strings.Join(got, ", ") != strings.Join(expected, ", ") {
+		t.Fatalf("got %v, want %v", got, expected)
+	}
+	if changes[0].Old[0].Content != "198.51.100.1" || changes[0].New[0].Content != "203.0.113.7" {
+		t.Fatalf("unexpected update: %+v", changes[0])
+	}
+
+	if changes := Diff(want, live, pending, nil); len(changes) != 2 {
+		t.Fatalf("expected no deletes without pruning, got %+v", changes)
+	}
+}
+
+func TestDiffDetectsTTLChange(t *testing.T) {
+	want := []provider.Record{{Domain: "example.com", Name: "@", Type: "A", Content: "203.0.113.7", TTL: 300}}
+	live := []provider.Record{{Domain: "example.com", Name: "@", Type: "A", Content: "203.0.113.7", TTL: 3600}}
+	changes := Diff(want, live, nil, nil)
+	if len(changes) != 1 || changes[0].Action != ActionUpdate {
+		t.Fatalf("expected a TTL update, got %+v", changes)
+	}
+	inv := changes[0].Inverse()
+	if inv.Action != ActionUpdate || inv.New[0].TTL != 3600 {
+		t.Fatalf("unexpected inverse: %+v", inv)
+	}
+}
+
+func TestVerify(t *testing.T) {
+	live := []provider.Record{{Domain: "example.com", Name: "@", Type: "A", Content: "198.51.100.1", TTL: 300}}
+	want := []provider.Record{
+		{Domain: "example.com", Name: "@", Type: "A", Content: "203.0.113.7", TTL: 300},
+		{Domain: "example.com", Name: "www", Type: "A", Content: "203.0.113.7", TTL: 300},
+	}
+	changes := Diff(want, live, nil, nil)
+	if err := Verify(changes, live); err != nil {
+		t.Fatalf("expected plan to match live state: %v", err)
+	}
+
+	edited := []provider.Record{{Domain: "example.com", Name: "@", Type: "A", Content: "198.51.100.2", TTL: 300}}
+	if err := Verify(changes, edited); !errors.Is(err, ErrStale) {
+		t.Fatalf("expected ErrStale after an edit, got %v", err)
+	}
+	created := append(live, provider.Record{Domain: "example.com", Name: "www", Type: "A", Content: "192.0.2.1", TTL: 300})
+	if err := Verify(changes, created); !errors.Is(err, ErrStale) {
+		t.Fatalf("expected ErrStale when a planned record appeared, got %v", err)
+	}
+}
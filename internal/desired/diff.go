@@ -0,0 +1,178 @@
+package desired
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+
+	"github.com/erkki/dnsupdater/internal/provider"
+)
+
+// Key identifies a record set: every record with the same domain, name and
+// type.
+type Key struct {
+	Domain string `json:"domain"`
+	Name   string `json:"name"`
+	Type   string `json:"type"`
+}
+
+// KeyOf returns the key of the set record belongs to.
+func KeyOf(record provider.Record) Key {
+	return Key{
+		Domain: strings.TrimSuffix(strings.ToLower(record.Domain), "."),
+		Name:   strings.ToLower(record.Name),
+		Type:   strings.ToUpper(record.Type),
+	}
+}
+
+func (k Key) String() string {
+	return k.Domain + " " + k.Name + " " + k.Type
+}
+
+// Action is the kind of a Change.
+type Action string
+
+const (
+	ActionCreate Action = "create"
+	ActionUpdate Action = "update"
+	ActionDelete Action = "delete"
+)
+
+// Change replaces the live records of one set (Old) with New. Old is empty for
+// creates and New is empty for deletes.
+type Change struct {
+	Action Action            `json:"action"`
+	Key    Key               `json:"key"`
+	Old    []provider.Record `json:"old,omitempty"`
+	New    []provider.Record `json:"new,omitempty"`
+}
+
+// Inverse returns the change that undoes c.
+func (c Change) Inverse() Change {
+	inv := Change{Action: ActionUpdate, Key: c.Key, Old: c.New, New: c.Old}
+	switch c.Action {
+	case ActionCreate:
+		inv.Action = ActionDelete
+	case ActionDelete:
+		inv.Action = ActionCreate
+	}
+	return inv
+}
+
+// Diff returns the changes that turn live into want. Live sets that are not
+// wanted are deleted only when prune reports true for one of their records and
+// their key is not pending; a nil prune never deletes. Changes are sorted by
+// key.
+func Diff(want, live []provider.Record, pending map[Key]bool, prune func(provider.Record) bool) []Change {
+	wantSets := groupSets(want)
+	liveSets := groupSets(live)
+
+	var changes []Change
+	for key, records := range wantSets {
+		current, ok := liveSets[key]
+		switch {
+		case !ok:
+			changes = append(changes, Change{Action: ActionCreate, Key: key, New: records})
+		case !equalSets(current, records):
+			changes = append(changes, Change{Action: ActionUpdate, Key: key, Old: current, New: records})
+		}
+	}
+	if prune != nil {
+		for key, records := range liveSets {
+			if _, ok := wantSets[key]; ok || pending[key] || !prune(records[0]) {
+				continue
+			}
+			changes = append(changes, Change{Action: ActionDelete, Key: key, Old: records})
+		}
+	}
+	sort.Slice(changes, func(i, j int) bool { return lessKey(changes[i].Key, changes[j].Key) })
+	return changes
+}
+
+// ErrStale is returned by Verify when live records no longer match the state
+// a set of changes was computed against.
+var ErrStale = errors.New("live records changed since the plan was made")
+
+// Verify checks that the live record sets still equal the Old side of every
+// change, so the changes can be applied as planned.
+func Verify(changes []Change, live []provider.Record) error {
+	liveSets := groupSets(live)
+	for _, change := range changes {
+		current := liveSets[change.Key]
+		if len(current) == 0 && len(change.Old) == 0 {
+			continue
+		}
+		if !equalSets(current, change.Old) {
+			return fmt.Errorf("%w: %s", ErrStale, change.Key)
+		}
+	}
+	return nil
+}
+
+func groupSets(records []provider.Record) map[Key][]provider.Record {
+	sets := make(map[Key][]provider.Record)
+	for _, record := range records {
+		key := KeyOf(record)
+		sets[key] = append(sets[key], record)
+	}
+	return sets
+}
+
+// equalSets compares two record sets by content and TTL, ignoring order.
+func equalSets(a, b []provider.Record) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	x, y := setItems(a), setItems(b)
+	for i := range x {
+		if x[i] != y[i] {
+			return false
+		}
+	}
+	return true
+}
+
+type setItem struct {
+	content string
+	ttl     int
+}
+
+func setItems(records []provider.Record) []setItem {
+	items := make([]setItem, len(records))
+	for i, record := range records {
+		items[i] = setItem{content: Normalize(record.Type, record.Content), ttl: record.TTL}
+	}
+	sort.Slice(items, func(i, j int) bool {
+		if items[i].content != items[j].content {
+			return items[i].content < items[j].content
+		}
+		return items[i].ttl < items[j].ttl
+	})
+	return items
+}
+
+// Normalize returns content in a canonical form for comparison: addresses in
+// their shortest form and host names lower-cased without the trailing dot.
+func Normalize(recordType, content string) string {
+	switch strings.ToUpper(recordType) {
+	case "A", "AAAA":
+		if ip := net.ParseIP(content); ip != nil {
+			return ip.String()
+		}
+	case "ALIAS", "CNAME", "NS", "PTR", "MX":
+		return strings.TrimSuffix(strings.ToLower(strings.TrimSpace(content)), ".")
+	}
+	return content
+}
+
+func lessKey(a, b Key) bool {
+	if a.Domain != b.Domain {
+		return a.Domain < b.Domain
+	}
+	if a.Name != b.Name {
+		return a.Name < b.Name
+	}
+	return a.Type < b.Type
+}
@@ -0,0 +1,116 @@
+package ipcheck
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/url"
+	"strings"
+
+	"github.com/miekg/dns"
+)
+
+// dnsScheme marks endpoints answered by a DNS server instead of an HTTP
+// service, e.g. "dns://208.67.222.222/myip.opendns.com" or
+// "dns://216.239.32.10/o-o.myaddr.l.google.com?type=TXT".
+const dnsScheme = "dns"
+
+// dnsQuery is a parsed DNS endpoint.
+type dnsQuery struct {
+	server string
+	name   string
+	// qtype is TypeA, TypeAAAA or TypeTXT; zero asks for the record type of
+	// the fetcher's family.
+	qtype uint16
+}
+
+func isDNS(rawURL string) bool {
+	return strings.HasPrefix(strings.ToLower(rawURL), dnsScheme+"://")
+}
+
+// parseDNSQuery reads a DNS endpoint URL: the server, with port 53 unless
+// given, the name to query as the path and an optional type parameter.
+func parseDNSQuery(rawURL string) (dnsQuery, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return dnsQuery{}, err
+	}
+	if u.Hostname() == "" {
+		return dnsQuery{}, fmt.Errorf("no DNS server in %s", rawURL)
+	}
+	port := u.Port()
+	if port == "" {
+		port = "53"
+	}
+	q := dnsQuery{server: net.JoinHostPort(u.Hostname(), port), name: strings.Trim(u.Path, "/")}
+	if q.name == "" {
+		return dnsQuery{}, fmt.Errorf("no name to query in %s", rawURL)
+	}
+	for key := range u.Query() {
+		if key != "type" {
+			return dnsQuery{}, fmt.Errorf("unknown parameter %q in %s", key, rawURL)
+		}
+	}
+	if t := u.Query().Get("type"); t != "" {
+		q.qtype = dns.StringToType[strings.ToUpper(t)]
+		if q.qtype != dns.TypeA && q.qtype != dns.TypeAAAA && q.qtype != dns.TypeTXT {
+			return dnsQuery{}, fmt.Errorf("unsupported query type %s in %s, want A, AAAA or TXT", t, rawURL)
+		}
+	}
+	return q, nil
+}
+
+// lookup asks the endpoint's DNS server directly, over the fetcher's family,
+// for the address it sees the query coming from. TXT answers are read with
+// the endpoint's parser.
+func (f *Fetcher) lookup(ctx context.Context, e Endpoint) (net.IP, error) {
+	q, err := parseDNSQuery(e.URL)
+	if err != nil {
+		return nil, err
+	}
+	if q.qtype == 0 {
+		q.qtype = dns.TypeA
+		if f.family == IPv6 {
+			q.qtype = dns.TypeAAAA
+		}
+	}
+	// SetQuestion draws the message ID from crypto/rand, so the answer,
+	// which decides what gets written, cannot easily be spoofed.
+	msg := new(dns.Msg)
+	msg.SetQuestion(dns.Fqdn(q.name), q.qtype)
+	network := "udp4"
+	if f.family == IPv6 {
+		network = "udp6"
+	}
+	client := &dns.Client{Net: network}
+	resp, _, err := client.ExchangeContext(ctx, msg, q.server)
+	if err != nil {
+		return nil, fmt.Errorf("query %s: %w", e.URL, err)
+	}
+	if resp.Rcode != dns.RcodeSuccess {
+		return nil, fmt.Errorf("query %s: %s", e.URL, dns.RcodeToString[resp.Rcode])
+	}
+
+	// Google's TXT answer may also carry the client subnet forwarded by a
+	// resolver, so the first record holding an address wins.
+	for _, rr := range resp.Answer {
+		if rr.Header().Rrtype != q.qtype || !strings.EqualFold(rr.Header().Name, msg.Question[0].Name) {
+			continue
+		}
+		var text string
+		switch rr := rr.(type) {
+		case *dns.A:
+			text = rr.A.String()
+		case *dns.AAAA:
+			text = rr.AAAA.String()
+		case *dns.TXT:
+			if text, err = e.extract([]byte(strings.Join(rr.Txt, ""))); err != nil {
+				continue
+			}
+		}
+		if ip := net.ParseIP(strings.TrimSpace(text)); ip != nil {
+			return ip, nil
+		}
+	}
+	return nil, fmt.Errorf("no address in the answer from %s", e.URL)
+}
@@ -0,0 +1,126 @@
+package ipcheck
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// dnsServer answers UDP queries on addr ("127.0.0.1:0" or "[::1]:0") with the
+// records returned by answer, or NXDOMAIN when there are none. It returns
+// the server's address.
+func dnsServer(t *testing.T, addr string, answer func(q dns.Question) []string) string {
+	t.Helper()
+	conn, err := net.ListenPacket("udp", addr)
+	if err != nil {
+		t.Skipf("cannot listen on %s: %v", addr, err)
+	}
+	srv := &dns.Server{PacketConn: conn, Handler: dns.HandlerFunc(func(w dns.ResponseWriter, req *dns.Msg) {
+		q := req.Question[0]
+		resp := new(dns.Msg)
+		resp.SetReply(req)
+		for _, content := range answer(q) {
+			rr, err := dns.NewRR(fmt.Sprintf("%s 0 IN %s %s", q.Name, dns.TypeToString[q.Qtype], content))
+			if err != nil {
+				t.Errorf("bad answer %q: %v", content, err)
+				continue
+			}
+			resp.Answer = append(resp.Answer, rr)
+		}
+		if len(resp.Answer) == 0 {
+			resp.Rcode = dns.RcodeNameError
+		}
+		w.WriteMsg(resp)
+	})}
+	go srv.ActivateAndServe()
+	t.Cleanup(func() { srv.Shutdown() })
+	return conn.LocalAddr().String()
+}
+
+func TestLookupA(t *testing.T) {
+	addr := dnsServer(t, "127.0.0.1:0", func(q dns.Question) []string {
+		if q.Qtype != dns.TypeA || !strings.EqualFold(q.Name, "myip.opendns.com.") {
+			return nil
+		}
+		return []string{"203.0.113.9"}
+	})
+	f := NewFetcher(IPv4, http.DefaultClient, []string{"dns://" + addr + "/myip.opendns.com"}, nil)
+	ip, err := f.CurrentIP(context.Background())
+	if err != nil || !ip.Equal(net.ParseIP("203.0.113.9")) {
+		t.Fatalf("expected the answer, got %v, %v", ip, err)
+	}
+
+	f = NewFetcher(IPv4, http.DefaultClient, []string{"dns://" + addr + "/unknown.example"}, nil)
+	if _, err := f.CurrentIP(context.Background()); err == nil {
+		t.Fatalf("expected NXDOMAIN to fail")
+	}
+}
+
+func TestLookupAAAA(t *testing.T) {
+	addr := dnsServer(t, "[::1]:0", func(q dns.Question) []string {
+		if q.Qtype != dns.TypeAAAA {
+			return nil
+		}
+		return []string{"2001:db8::9"}
+	})
+	f := NewFetcher(IPv6, http.DefaultClient, []string{"dns://" + addr + "/myip.opendns.com"}, nil)
+	ip, err := f.CurrentIP(context.Background())
+	if err != nil || !ip.Equal(net.ParseIP("2001:db8::9")) {
+		t.Fatalf("expected the answer, got %v, %v", ip, err)
+	}
+}
+
+func TestLookupTXT(t *testing.T) {
+	addr := dnsServer(t, "127.0.0.1:0", func(q dns.Question) []string {
+		if q.Qtype != dns.TypeTXT {
+			return nil
+		}
+		return []string{`"edns0-client-subnet 198.51.100.0/24"`, `"203.0.113.9"`}
+	})
+	f := NewFetcher(IPv4, http.DefaultClient, []string{"dns://" + addr + "/o-o.myaddr.l.google.com?type=TXT"}, nil)
+	ip, err := f.CurrentIP(context.Background())
+	if err != nil || !ip.Equal(net.ParseIP("203.0.113.9")) {
+		t.Fatalf("expected the address record, got %v, %v", ip, err)
+	}
+}
+
+func TestLookupAlongsideHTTP(t *testing.T) {
+	addr := dnsServer(t, "127.0.0.1:0", func(q dns.Question) []string {
+		return []string{"203.0.113.9"}
+	})
+	endpoints, err := ParseEndpoints(append(echoServers(t, "203.0.113.9", "192.0.2.1"), "dns://"+addr+"/myip.opendns.com timeout=1s"))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	f := NewFetcher(IPv4, &http.Client{Timeout: time.Second}, nil, nil)
+	f.SetEndpoints(endpoints)
+	f.SetMode(ModeQuorum)
+	ip, err := f.CurrentIP(context.Background())
+	if err != nil || !ip.Equal(net.ParseIP("203.0.113.9")) {
+		t.Fatalf("expected the DNS answer to decide the vote, got %v, %v", ip, err)
+	}
+}
+
+func TestParseDNSEndpoint(t *testing.T) {
+	for _, bad := range []string{
+		"dns:///myip.opendns.com",
+		"dns://208.67.222.222",
+		"dns://208.67.222.222/myip.opendns.com?type=MX",
+		"dns://208.67.222.222/myip.opendns.com?class=CH",
+		"dns://208.67.222.222/myip.opendns.com method=POST",
+	} {
+		if _, err := ParseEndpoint(bad); err == nil {
+			t.Errorf("expected %q to be rejected", bad)
+		}
+	}
+	q, err := parseDNSQuery("dns://[2620:119:35::35]/myip.opendns.com")
+	if err != nil || q.server != "[2620:119:35::35]:53" || q.name != "myip.opendns.com" || q.qtype != 0 {
+		t.Fatalf("unexpected query: %+v, %v", q, err)
+	}
+}
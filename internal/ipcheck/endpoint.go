@@ -0,0 +1,269 @@
+package ipcheck
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Response parsers of an Endpoint.
+const (
+	// ParserPlain reads the address from the first line of the body.
+	ParserPlain = "plain"
+	// ParserJSON reads the address at Path in a JSON body.
+	ParserJSON = "json"
+	// ParserRegex reads the address matched by Pattern: its first capture
+	// group, or the whole match when it has none.
+	ParserRegex = "regex"
+)
+
+// Endpoint is a service reporting the public IP of the caller: an HTTP
+// service, a DNS server when the URL has the dns scheme (see lookup) or a
+// STUN server with the stun scheme (see bind).
+type Endpoint struct {
+	// Preset names a built-in endpoint definition (see Presets) to use
+	// instead of URL. Timeout and Priority, when set, override the preset's;
+	// the request and parser settings cannot be combined with it.
+	Preset string `yaml:"preset"`
+	URL    string `yaml:"url"`
+	// Method defaults to GET.
+	Method  string            `yaml:"method"`
+	Headers map[string]string `yaml:"headers"`
+	// Parser is ParserPlain (the default), ParserJSON or ParserRegex. For DNS
+	// endpoints it applies to TXT answers.
+	Parser string `yaml:"parser"`
+	// Path locates the address in a JSON body: keys and array indexes
+	// separated by dots, e.g. "ip" or "data.addresses.0".
+	Path    string `yaml:"path"`
+	Pattern string `yaml:"pattern"`
+	// Family restricts the endpoint to one address family. Empty means the
+	// endpoint answers for either, depending on how it is reached.
+	Family Family `yaml:"family"`
+	// Timeout bounds each request; zero means 10 seconds.
+	Timeout time.Duration `yaml:"timeout"`
+	// Priority orders the endpoints: lower values are tried, or started in a
+	// race, first. Endpoints of equal priority keep their configured order.
+	Priority int `yaml:"priority"`
+
+	// re is Pattern, compiled by Validate.
+	re *regexp.Regexp
+}
+
+func (e Endpoint) timeout() time.Duration {
+	if e.Timeout > 0 {
+		return e.Timeout
+	}
+	return requestTimeout
+}
+
+// Validate checks the endpoint's settings and compiles its pattern. An
+// endpoint using ParserRegex must be validated before it is queried.
+func (e *Endpoint) Validate() error {
+	if e.Preset != "" {
+		if _, ok := presets[e.Preset]; !ok {
+			return fmt.Errorf("unknown endpoint preset %q", e.Preset)
+		}
+		if e.URL != "" || e.Method != "" || len(e.Headers) > 0 || e.Parser != "" || e.Path != "" || e.Pattern != "" {
+			return fmt.Errorf("endpoint preset %s takes no url, method, headers, parser, path or pattern", e.Preset)
+		}
+		return nil
+	}
+	if e.URL == "" {
+		return fmt.Errorf("endpoint needs a url or a preset")
+	}
+	if isDNS(e.URL) {
+		if _, err := parseDNSQuery(e.URL); err != nil {
+			return fmt.Errorf("endpoint %s: %w", e.URL, err)
+		}
+		if e.Method != "" || len(e.Headers) > 0 {
+			return fmt.Errorf("endpoint %s: DNS endpoints take no method or headers", e.URL)
+		}
+	}
+	if isSTUN(e.URL) {
+		if _, err := parseSTUNServer(e.URL); err != nil {
+			return fmt.Errorf("endpoint %s: %w", e.URL, err)
+		}
+		if e.Method != "" || len(e.Headers) > 0 || e.Parser != "" {
+			return fmt.Errorf("endpoint %s: STUN endpoints take no method, headers or parser", e.URL)
+		}
+	}
+	switch e.Parser {
+	case "", ParserPlain:
+	case ParserJSON:
+		if e.Path == "" {
+			return fmt.Errorf("endpoint %s: json parser needs a path", e.URL)
+		}
+	case ParserRegex:
+		re, err := regexp.Compile(e.Pattern)
+		if err != nil || e.Pattern == "" {
+			return fmt.Errorf("endpoint %s: invalid pattern %q: %v", e.URL, e.Pattern, err)
+		}
+		e.re = re
+	default:
+		return fmt.Errorf("endpoint %s: unknown parser %q", e.URL, e.Parser)
+	}
+	if e.Family != "" && e.Family != IPv4 && e.Family != IPv6 {
+		return fmt.Errorf("endpoint %s: unknown family %q", e.URL, e.Family)
+	}
+	if e.Timeout < 0 {
+		return fmt.Errorf("endpoint %s: negative timeout", e.URL)
+	}
+	return nil
+}
+
+// extract returns the address text in body according to the parser.
+func (e Endpoint) extract(body []byte) (string, error) {
+	switch e.Parser {
+	case ParserJSON:
+		var v any
+		if err := json.Unmarshal(body, &v); err != nil {
+			return "", err
+		}
+		for _, key := range strings.Split(e.Path, ".") {
+			switch node := v.(type) {
+			case map[string]any:
+				v = node[key]
+			case []any:
+				i, err := strconv.Atoi(key)
+				if err != nil || i < 0 || i >= len(node) {
+					return "", fmt.Errorf("no index %q in JSON array", key)
+				}
+				v = node[i]
+			default:
+				v = nil
+			}
+			if v == nil {
+				return "", fmt.Errorf("no %q in JSON response", e.Path)
+			}
+		}
+		s, ok := v.(string)
+		if !ok {
+			return "", fmt.Errorf("%q in JSON response is not a string", e.Path)
+		}
+		return s, nil
+	case ParserRegex:
+		if e.re == nil {
+			return "", fmt.Errorf("pattern %q was not compiled by Validate", e.Pattern)
+		}
+		m := e.re.FindSubmatch(body)
+		if m == nil {
+			return "", fmt.Errorf("no match for %q", e.Pattern)
+		}
+		if len(m) > 1 {
+			return string(m[1]), nil
+		}
+		return string(m[0]), nil
+	}
+	line, _, _ := strings.Cut(string(body), "\n")
+	if strings.TrimSpace(line) == "" {
+		return "", fmt.Errorf("empty response")
+	}
+	return line, nil
+}
+
+func (e Endpoint) method() string {
+	if e.Method == "" {
+		return http.MethodGet
+	}
+	return strings.ToUpper(e.Method)
+}
+
+// ParseEndpoint reads an endpoint written as its URL, or the name of a
+// preset, followed by optional "key=value" fields separated by spaces, e.g.
+// "https://api.ipify.org timeout=3s priority=1". The keys are timeout,
+// priority, method, parser, path, pattern, family and header (as
+// "header=Name:value", repeatable).
+func ParseEndpoint(s string) (Endpoint, error) {
+	fields := strings.Fields(s)
+	if len(fields) == 0 {
+		return Endpoint{}, fmt.Errorf("empty endpoint")
+	}
+	var e Endpoint
+	if strings.Contains(fields[0], "://") {
+		e.URL = fields[0]
+	} else {
+		e.Preset = fields[0]
+	}
+	for _, field := range fields[1:] {
+		key, value, ok := strings.Cut(field, "=")
+		if !ok || value == "" {
+			return Endpoint{}, fmt.Errorf("invalid endpoint field %q, want key=value", field)
+		}
+		var err error
+		switch strings.ToLower(key) {
+		case "timeout":
+			e.Timeout, err = time.ParseDuration(value)
+			if err == nil && e.Timeout <= 0 {
+				err = fmt.Errorf("must be positive")
+			}
+		case "priority":
+			e.Priority, err = strconv.Atoi(value)
+		case "method":
+			e.Method = value
+		case "parser":
+			e.Parser = value
+		case "path":
+			e.Path = value
+		case "pattern":
+			e.Pattern = value
+		case "family":
+			e.Family, err = ParseFamily(value)
+		case "header":
+			name, v, ok := strings.Cut(value, ":")
+			if !ok {
+				err = fmt.Errorf("want Name:value")
+				break
+			}
+			if e.Headers == nil {
+				e.Headers = make(map[string]string)
+			}
+			e.Headers[name] = v
+		default:
+			return Endpoint{}, fmt.Errorf("unknown endpoint field %q", key)
+		}
+		if err != nil {
+			return Endpoint{}, fmt.Errorf("invalid endpoint %s %s: %w", fields[0], key, err)
+		}
+	}
+	if err := e.Validate(); err != nil {
+		return Endpoint{}, err
+	}
+	return e, nil
+}
+
+// ParseEndpoints reads endpoints with ParseEndpoint.
+func ParseEndpoints(list []string) ([]Endpoint, error) {
+	endpoints := make([]Endpoint, 0, len(list))
+	for _, s := range list {
+		e, err := ParseEndpoint(s)
+		if err != nil {
+			return nil, err
+		}
+		endpoints = append(endpoints, e)
+	}
+	return endpoints, nil
+}
+
+// SetEndpoints replaces the fetcher's endpoints. Presets are expanded and
+// endpoints restricted to the other family are left out. Endpoints should
+// have been checked with Validate.
+func (f *Fetcher) SetEndpoints(endpoints []Endpoint) {
+	var kept []Endpoint
+	for _, e := range ExpandPresets(endpoints) {
+		if e.Family == "" || e.Family == f.family {
+			kept = append(kept, e)
+		}
+	}
+	sort.SliceStable(kept, func(i, j int) bool { return kept[i].Priority < kept[j].Priority })
+	f.endpoints = kept
+}
+
+// Endpoints returns the endpoints the fetcher queries, in priority order.
+func (f *Fetcher) Endpoints() []Endpoint {
+	return append([]Endpoint(nil), f.endpoints...)
+}
@@ -1,9 +1,10 @@
 package ipcheck
 
 import (
-	"bufio"
 	"context"
 	"fmt"
+	"io"
+	"log/slog"
 	"net"
 	"net/http"
 	"strings"
@@ -12,15 +13,99 @@ import (
 
 const requestTimeout = 10 * time.Second
 
-// Fetcher retrieves the current public IP using a list of services.
+// Family identifies an IP address family.
+type Family string
+
+const (
+	IPv4 Family = "ipv4"
+	IPv6 Family = "ipv6"
+)
+
+// ParseFamily converts a configuration value such as "ipv4" or "6" into a Family.
+func ParseFamily(s string) (Family, error) {
+	switch strings.ToLower(strings.TrimSpace(s)) {
+	case "ipv4", "v4", "4", "a":
+		return IPv4, nil
+	case "ipv6", "v6", "6", "aaaa":
+		return IPv6, nil
+	default:
+		return "", fmt.Errorf("unknown address family %q", s)
+	}
+}
+
+// FamilyOf returns the family of ip.
+func FamilyOf(ip net.IP) Family {
+	if ip.To4() != nil {
+		return IPv4
+	}
+	return IPv6
+}
+
+// Matches reports whether ip belongs to the family.
+func (f Family) Matches(ip net.IP) bool {
+	if ip == nil {
+		return false
+	}
+	return FamilyOf(ip) == f
+}
+
+// RecordType returns the DNS record type that carries addresses of the family.
+func (f Family) RecordType() string {
+	if f == IPv6 {
+		return "AAAA"
+	}
+	return "A"
+}
+
+func (f Family) network() string {
+	if f == IPv6 {
+		return "tcp6"
+	}
+	return "tcp4"
+}
+
+// NewHTTPClient returns an HTTP client whose connections are forced over the
+// given address family, so dual-stack endpoints report the matching address.
+func NewHTTPClient(family Family) *http.Client {
+	dialer := &net.Dialer{Timeout: requestTimeout, KeepAlive: 30 * time.Second}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.DialContext = func(ctx context.Context, _, addr string) (net.Conn, error) {
+		return dialer.DialContext(ctx, family.network(), addr)
+	}
+	return &http.Client{Transport: transport}
+}
+
+// Fetcher retrieves the current public IP of one address family using a list of services.
 type Fetcher struct {
+	family    Family
 	client    *http.Client
-	endpoints []string
+	endpoints []Endpoint
 	mockIP    net.IP
+	mode      Mode
+	quorum    int
+	stagger   time.Duration
+	logger    *slog.Logger
 }
 
-func NewFetcher(client *http.Client, endpoints []string, mockIP net.IP) *Fetcher {
-	return &Fetcher{client: client, endpoints: endpoints, mockIP: mockIP}
+// NewFetcher returns a fetcher querying the endpoint URLs in order. Use
+// SetEndpoints for per-endpoint timeouts and priorities.
+func NewFetcher(family Family, client *http.Client, endpoints []string, mockIP net.IP) *Fetcher {
+	f := &Fetcher{family: family, client: client, mockIP: mockIP, mode: ModeFirst,
+		logger: slog.New(slog.NewTextHandler(io.Discard, nil))}
+	for _, url := range endpoints {
+		f.endpoints = append(f.endpoints, Endpoint{URL: url})
+	}
+	return f
+}
+
+// SetLogger sets the logger used to report misbehaving endpoints.
+func (f *Fetcher) SetLogger(logger *slog.Logger) {
+	f.logger = logger
+}
+
+// Family returns the address family the fetcher detects.
+func (f *Fetcher) Family() Family {
+	return f.family
 }
 
 func (f *Fetcher) CurrentIP(ctx context.Context) (net.IP, error) {
@@ -29,7 +114,13 @@ func (f *Fetcher) CurrentIP(ctx context.Context) (net.IP, error) {
 		return f.mockIP, nil
 	}
 	if len(f.endpoints) == 0 {
-		return nil, fmt.Errorf("no IP endpoints configured")
+		return nil, fmt.Errorf("no %s endpoints configured", f.family)
+	}
+	switch f.mode {
+	case ModeQuorum:
+		return f.currentIPQuorum(ctx)
+	case ModeRace:
+		return f.currentIPRace(ctx)
 	}
 	for _, endpoint := range f.endpoints {
 		ip, err := f.fetch(ctx, endpoint)
@@ -37,17 +128,44 @@ func (f *Fetcher) CurrentIP(ctx context.Context) (net.IP, error) {
 			return ip, nil
 		}
 	}
-	return nil, fmt.Errorf("all IP endpoints failed")
+	return nil, fmt.Errorf("all %s endpoints failed", f.family)
 }
 
-func (f *Fetcher) fetch(ctx context.Context, endpoint string) (net.IP, error) {
-	req, err := http.NewRequestWithContext(ctx, http.MethodGet, endpoint, nil)
+// maxBody bounds how much of an endpoint's response is read.
+const maxBody = 64 << 10
+
+func (f *Fetcher) fetch(ctx context.Context, e Endpoint) (net.IP, error) {
+	ctx, cancel := context.WithTimeout(ctx, e.timeout())
+	defer cancel()
+	var ip net.IP
+	var err error
+	switch {
+	case isDNS(e.URL):
+		ip, err = f.lookup(ctx, e)
+	case isSTUN(e.URL):
+		ip, err = f.bind(ctx, e)
+	default:
+		ip, err = f.get(ctx, e)
+	}
 	if err != nil {
 		return nil, err
 	}
-	ctx, cancel := context.WithTimeout(req.Context(), requestTimeout)
-	defer cancel()
-	req = req.WithContext(ctx)
+	if !f.family.Matches(ip) {
+		return nil, fmt.Errorf("endpoint %s returned %s address %s, want %s", e.URL, FamilyOf(ip), ip, f.family)
+	}
+	return ip, nil
+}
+
+// get reads the address from an HTTP endpoint.
+func (f *Fetcher) get(ctx context.Context, e Endpoint) (net.IP, error) {
+	endpoint := e.URL
+	req, err := http.NewRequestWithContext(ctx, e.method(), endpoint, nil)
+	if err != nil {
+		return nil, err
+	}
+	for name, value := range e.Headers {
+		req.Header.Set(name, value)
+	}
 
 	resp, err := f.client.Do(req)
 	if err != nil {
@@ -58,14 +176,18 @@ func (f *Fetcher) fetch(ctx context.Context, endpoint string) (net.IP, error) {
 		return nil, fmt.Errorf("endpoint %s returned %d", endpoint, resp.StatusCode)
 	}
 
-	scanner := bufio.NewScanner(resp.Body)
-	if !scanner.Scan() {
-		return nil, fmt.Errorf("empty response from %s", endpoint)
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxBody))
+	if err != nil {
+		return nil, fmt.Errorf("read response from %s: %w", endpoint, err)
+	}
+	text, err := e.extract(body)
+	if err != nil {
+		return nil, fmt.Errorf("parse response from %s: %w", endpoint, err)
 	}
-	line := strings.TrimSpace(scanner.Text())
-	ip := net.ParseIP(line)
+	text = strings.TrimSpace(text)
+	ip := net.ParseIP(text)
 	if ip == nil {
-		return nil, fmt.Errorf("invalid IP '%s' from %s", line, endpoint)
+		return nil, fmt.Errorf("invalid IP '%s' from %s", text, endpoint)
 	}
 	return ip, nil
 }
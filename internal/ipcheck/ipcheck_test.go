@@ -2,6 +2,7 @@ package ipcheck
 
 import (
 	"context"
+	"errors"
 	"net"
 	"net/http"
 	"net/http/httptest"
@@ -15,7 +16,7 @@ func TestCurrentIP(t *testing.T) {
 	}))
 	t.Cleanup(srv.Close)
 
-	f := NewFetcher(srv.Client(), []string{srv.URL}, nil)
+	f := NewFetcher(IPv4, srv.Client(), []string{srv.URL}, nil)
 	ip, err := f.CurrentIP(context.Background())
 	if err != nil {
 		t.Fatalf("unexpected error: %v", err)
@@ -37,7 +38,7 @@ func TestCurrentIPFallback(t *testing.T) {
 	t.Cleanup(good.Close)
 
 	client := &http.Client{Timeout: time.Second}
-	f := NewFetcher(client, []string{bad.URL, good.URL}, nil)
+	f := NewFetcher(IPv4, client, []string{bad.URL, good.URL}, nil)
 	ip, err := f.CurrentIP(context.Background())
 	if err != nil {
 		t.Fatalf("expected success, got %v", err)
@@ -49,7 +50,7 @@ func TestCurrentIPFallback(t *testing.T) {
 
 func TestCurrentIPMock(t *testing.T) {
 	mockIP := net.ParseIP("192.0.2.1")
-	f := NewFetcher(nil, []string{}, mockIP)
+	f := NewFetcher(IPv4, nil, []string{}, mockIP)
 	ip, err := f.CurrentIP(context.Background())
 	if err != nil {
 		t.Fatalf("unexpected error: %v", err)
@@ -58,3 +59,284 @@ func TestCurrentIPMock(t *testing.T) {
 		t.Fatalf("expected mock IP %s, got %s", mockIP.String(), ip.String())
 	}
 }
+
+func TestCurrentIPFamilyMismatch(t *testing.T) {
+	v4 := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte("203.0.113.10"))
+	}))
+	t.Cleanup(v4.Close)
+
+	v6 := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte("2001:db8::10\n"))
+	}))
+	t.Cleanup(v6.Close)
+
+	f := NewFetcher(IPv6, v4.Client(), []string{v4.URL, v6.URL}, nil)
+	ip, err := f.CurrentIP(context.Background())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !ip.Equal(net.ParseIP("2001:db8::10")) {
+		t.Fatalf("expected IPv6 answer, got %s", ip)
+	}
+
+	f = NewFetcher(IPv6, v4.Client(), []string{v4.URL}, nil)
+	if _, err := f.CurrentIP(context.Background()); err == nil {
+		t.Fatalf("expected IPv4 answer to be rejected for IPv6 fetcher")
+	}
+}
+
+// echoServers starts one server per answer; an empty answer fails with 500.
+func echoServers(t *testing.T, answers ...string) []string {
+	t.Helper()
+	var urls []string
+	for _, answer := range answers {
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if answer == "" {
+				w.WriteHeader(http.StatusInternalServerError)
+				return
+			}
+			_, _ = w.Write([]byte(answer))
+		}))
+		t.Cleanup(srv.Close)
+		urls = append(urls, srv.URL)
+	}
+	return urls
+}
+
+func TestCurrentIPQuorum(t *testing.T) {
+	urls := echoServers(t, "198.51.100.66", "203.0.113.10", "", "203.0.113.10")
+	f := NewFetcher(IPv4, &http.Client{Timeout: time.Second}, urls, nil)
+	f.SetMode(ModeQuorum)
+	f.SetQuorum(2)
+	ip, err := f.CurrentIP(context.Background())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !ip.Equal(net.ParseIP("203.0.113.10")) {
+		t.Fatalf("expected the agreed address, got %s", ip)
+	}
+}
+
+func TestCurrentIPQuorumIgnoresFailedEndpoints(t *testing.T) {
+	urls := echoServers(t, "203.0.113.10", "", "198.51.100.66", "203.0.113.10")
+	f := NewFetcher(IPv4, &http.Client{Timeout: time.Second}, urls, nil)
+	f.SetMode(ModeQuorum)
+	ip, err := f.CurrentIP(context.Background())
+	if err != nil || !ip.Equal(net.ParseIP("203.0.113.10")) {
+		t.Fatalf("expected a majority of the answers to decide, got %v, %v", ip, err)
+	}
+
+	// A lone answer is not enough, but it is no disagreement either.
+	f = NewFetcher(IPv4, &http.Client{Timeout: time.Second}, echoServers(t, "203.0.113.10", ""), nil)
+	f.SetMode(ModeQuorum)
+	if _, err := f.CurrentIP(context.Background()); err == nil || errors.Is(err, ErrNoConsensus) {
+		t.Fatalf("expected a plain failure, got %v", err)
+	}
+}
+
+func TestCurrentIPNoConsensus(t *testing.T) {
+	tests := map[string][]string{
+		"majority not reached": {"198.51.100.66", "203.0.113.10", ""},
+		"tie":                  {"198.51.100.66", "203.0.113.10", "198.51.100.66", "203.0.113.10"},
+	}
+	for name, answers := range tests {
+		urls := echoServers(t, answers...)
+		f := NewFetcher(IPv4, &http.Client{Timeout: time.Second}, urls, nil)
+		f.SetMode(ModeQuorum)
+		if name == "tie" {
+			f.SetQuorum(2)
+		}
+		_, err := f.CurrentIP(context.Background())
+		var consensus *ConsensusError
+		if !errors.Is(err, ErrNoConsensus) || !errors.As(err, &consensus) {
+			t.Fatalf("%s: expected no consensus, got %v", name, err)
+		}
+		if len(consensus.Votes["203.0.113.10"]) == 0 || len(consensus.Votes["198.51.100.66"]) == 0 {
+			t.Fatalf("%s: disagreeing endpoints not reported: %+v", name, consensus.Votes)
+		}
+	}
+
+	// When no endpoint answers, there is nothing to disagree on.
+	f := NewFetcher(IPv4, &http.Client{Timeout: time.Second}, echoServers(t, "", ""), nil)
+	f.SetMode(ModeQuorum)
+	if _, err := f.CurrentIP(context.Background()); err == nil || errors.Is(err, ErrNoConsensus) {
+		t.Fatalf("expected a plain failure, got %v", err)
+	}
+}
+
+func TestParseEndpoint(t *testing.T) {
+	e, err := ParseEndpoint("https://api.ipify.org timeout=3s priority=-1")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if e.URL != "https://api.ipify.org" || e.Timeout != 3*time.Second || e.Priority != -1 {
+		t.Fatalf("unexpected endpoint: %+v", e)
+	}
+	for _, bad := range []string{"", "https://x timeout=0s", "https://x priority=high", "https://x retries=2", "https://x timeout"} {
+		if _, err := ParseEndpoint(bad); err == nil {
+			t.Errorf("expected %q to be rejected", bad)
+		}
+	}
+}
+
+func TestEndpointPriorityAndTimeout(t *testing.T) {
+	hanging := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-r.Context().Done()
+	}))
+	t.Cleanup(hanging.Close)
+	urls := echoServers(t, "198.51.100.5")
+
+	f := NewFetcher(IPv4, hanging.Client(), nil, nil)
+	f.SetEndpoints([]Endpoint{
+		{URL: urls[0], Priority: 2},
+		{URL: hanging.URL, Priority: 1, Timeout: 50 * time.Millisecond},
+	})
+	start := time.Now()
+	ip, err := f.CurrentIP(context.Background())
+	if err != nil || !ip.Equal(net.ParseIP("198.51.100.5")) {
+		t.Fatalf("expected fallback after the timeout, got %v, %v", ip, err)
+	}
+	if elapsed := time.Since(start); elapsed < 50*time.Millisecond || elapsed > time.Second {
+		t.Fatalf("expected the hanging endpoint to be tried first and time out, took %s", elapsed)
+	}
+}
+
+func TestCurrentIPRace(t *testing.T) {
+	cancelled := make(chan struct{})
+	slow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-r.Context().Done():
+			close(cancelled)
+		case <-time.After(5 * time.Second):
+			_, _ = w.Write([]byte("192.0.2.1"))
+		}
+	}))
+	t.Cleanup(slow.Close)
+	fast := echoServers(t, "203.0.113.10")
+
+	f := NewFetcher(IPv4, slow.Client(), []string{slow.URL, fast[0]}, nil)
+	f.SetMode(ModeRace)
+	f.SetStagger(20 * time.Millisecond)
+	start := time.Now()
+	ip, err := f.CurrentIP(context.Background())
+	if err != nil || !ip.Equal(net.ParseIP("203.0.113.10")) {
+		t.Fatalf("expected the fast answer, got %v, %v", ip, err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Fatalf("race waited for the slow endpoint, took %s", elapsed)
+	}
+	select {
+	case <-cancelled:
+	case <-time.After(time.Second):
+		t.Fatalf("the slow request was not cancelled")
+	}
+}
+
+func TestCurrentIPRaceStartsNextOnFailure(t *testing.T) {
+	urls := echoServers(t, "", "203.0.113.10")
+	f := NewFetcher(IPv4, &http.Client{Timeout: time.Second}, urls, nil)
+	f.SetMode(ModeRace)
+	f.SetStagger(time.Minute)
+	start := time.Now()
+	ip, err := f.CurrentIP(context.Background())
+	if err != nil || !ip.Equal(net.ParseIP("203.0.113.10")) {
+		t.Fatalf("expected the second answer, got %v, %v", ip, err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Fatalf("failure did not start the next endpoint early, took %s", elapsed)
+	}
+
+	f = NewFetcher(IPv4, &http.Client{Timeout: time.Second}, echoServers(t, "", ""), nil)
+	f.SetMode(ModeRace)
+	if _, err := f.CurrentIP(context.Background()); err == nil {
+		t.Fatalf("expected every endpoint to fail")
+	}
+}
+
+func TestEndpointParsers(t *testing.T) {
+	var gotMethod, gotHeader string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotMethod, gotHeader = r.Method, r.Header.Get("X-Token")
+		switch r.URL.Path {
+		case "/json":
+			_, _ = w.Write([]byte(`{"data":{"addresses":["198.51.100.7"]}}`))
+		case "/trace":
+			_, _ = w.Write([]byte("fl=1\nip=2001:db8::7\nts=2\n"))
+		}
+	}))
+	t.Cleanup(srv.Close)
+
+	tests := []struct {
+		endpoint Endpoint
+		family   Family
+		want     string
+	}{
+		{Endpoint{URL: srv.URL + "/json", Parser: ParserJSON, Path: "data.addresses.0"}, IPv4, "198.51.100.7"},
+		{Endpoint{URL: srv.URL + "/trace", Parser: ParserRegex, Pattern: `(?m)^ip=(\S+)$`}, IPv6, "2001:db8::7"},
+	}
+	for _, tt := range tests {
+		if err := tt.endpoint.Validate(); err != nil {
+			t.Fatalf("%s: %v", tt.endpoint.URL, err)
+		}
+		f := NewFetcher(tt.family, srv.Client(), nil, nil)
+		f.SetEndpoints([]Endpoint{tt.endpoint})
+		ip, err := f.CurrentIP(context.Background())
+		if err != nil || !ip.Equal(net.ParseIP(tt.want)) {
+			t.Errorf("%s: got %v, %v", tt.endpoint.URL, ip, err)
+		}
+	}
+
+	e, err := ParseEndpoint(srv.URL + "/json parser=json path=data.missing method=post header=X-Token:secret")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	f := NewFetcher(IPv4, srv.Client(), nil, nil)
+	f.SetEndpoints([]Endpoint{e})
+	if _, err := f.CurrentIP(context.Background()); err == nil {
+		t.Fatalf("expected a missing JSON path to fail")
+	}
+	if gotMethod != http.MethodPost || gotHeader != "secret" {
+		t.Fatalf("expected POST with the header, got %s %q", gotMethod, gotHeader)
+	}
+}
+
+func TestEndpointValidate(t *testing.T) {
+	for _, bad := range []Endpoint{
+		{},
+		{Preset: "nope"},
+		{URL: "https://x", Parser: ParserJSON},
+		{URL: "https://x", Parser: ParserRegex},
+		{URL: "https://x", Parser: ParserRegex, Pattern: "("},
+		{URL: "https://x", Parser: "xml"},
+		{URL: "https://x", Family: "ipv5"},
+		{URL: "https://x", Timeout: -time.Second},
+		{Preset: "ipify", URL: "https://x"},
+		{Preset: "ipify", Parser: ParserJSON, Path: "address"},
+		{Preset: "cloudflare", Pattern: `ip=(\S+)`},
+	} {
+		if err := bad.Validate(); err == nil {
+			t.Errorf("expected %+v to be rejected", bad)
+		}
+	}
+}
+
+func TestPresetsFollowFamily(t *testing.T) {
+	endpoints := []Endpoint{{Preset: "ipify", Priority: 3}, {Preset: "aws"}, {Preset: "ifconfig.co"}}
+
+	f := NewFetcher(IPv6, http.DefaultClient, nil, nil)
+	f.SetEndpoints(endpoints)
+	got := f.Endpoints()
+	if len(got) != 2 || got[0].URL != "https://ifconfig.co/json" || got[1].URL != "https://api6.ipify.org?format=json" || got[1].Priority != 3 {
+		t.Fatalf("unexpected IPv6 endpoints: %+v", got)
+	}
+
+	f = NewFetcher(IPv4, http.DefaultClient, nil, nil)
+	f.SetEndpoints(endpoints)
+	if got := f.Endpoints(); len(got) != 3 {
+		t.Fatalf("unexpected IPv4 endpoints: %+v", got)
+	}
+	if e, err := ParseEndpoint("cloudflare family=ipv6"); err != nil || e.Preset != "cloudflare" {
+		t.Fatalf("expected a preset, got %+v, %v", e, err)
+	}
+}
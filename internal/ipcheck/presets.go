@@ -0,0 +1,101 @@
+package ipcheck
+
+import (
+	"fmt"
+	"sort"
+)
+
+// presets are the built-in endpoint definitions, by name.
+var presets = map[string][]Endpoint{
+	"ipify": {
+		{URL: "https://api.ipify.org?format=json", Parser: ParserJSON, Path: "ip", Family: IPv4},
+		{URL: "https://api6.ipify.org?format=json", Parser: ParserJSON, Path: "ip", Family: IPv6},
+	},
+	"icanhazip": {
+		{URL: "https://ipv4.icanhazip.com", Family: IPv4},
+		{URL: "https://ipv6.icanhazip.com", Family: IPv6},
+	},
+	"ident.me": {
+		{URL: "https://v4.ident.me", Family: IPv4},
+		{URL: "https://v6.ident.me", Family: IPv6},
+	},
+	// ifconfig.co is dual-stack and answers for the family it is reached
+	// over.
+	"ifconfig.co": {
+		{URL: "https://ifconfig.co/json", Parser: ParserJSON, Path: "ip", Headers: map[string]string{"Accept": "application/json"}},
+	},
+	"aws": {
+		{URL: "https://checkip.amazonaws.com", Family: IPv4},
+	},
+	"cloudflare": {
+		{URL: "https://1.1.1.1/cdn-cgi/trace", Parser: ParserRegex, Pattern: `(?m)^ip=(\S+)$`, Family: IPv4},
+		{URL: "https://[2606:4700:4700::1111]/cdn-cgi/trace", Parser: ParserRegex, Pattern: `(?m)^ip=(\S+)$`, Family: IPv6},
+	},
+	// The DNS presets query the servers directly, so the answer is the
+	// address they see rather than that of a local resolver.
+	"opendns": {
+		{URL: "dns://208.67.222.222/myip.opendns.com", Family: IPv4},
+		{URL: "dns://[2620:119:35::35]/myip.opendns.com", Family: IPv6},
+	},
+	"google-dns": {
+		{URL: "dns://216.239.32.10/o-o.myaddr.l.google.com?type=TXT", Family: IPv4},
+		{URL: "dns://[2001:4860:4802:32::a]/o-o.myaddr.l.google.com?type=TXT", Family: IPv6},
+	},
+	// The STUN servers are dual-stack and answer for the family they are
+	// reached over.
+	"google-stun": {
+		{URL: "stun://stun.l.google.com:19302"},
+	},
+	"cloudflare-stun": {
+		{URL: "stun://stun.cloudflare.com:3478"},
+	},
+}
+
+// The presets are validated once so their patterns are compiled.
+func init() {
+	for name, endpoints := range presets {
+		for i := range endpoints {
+			if err := endpoints[i].Validate(); err != nil {
+				panic(fmt.Sprintf("preset %s: %v", name, err))
+			}
+		}
+	}
+}
+
+// Presets returns the names of the built-in endpoint definitions.
+func Presets() []string {
+	names := make([]string, 0, len(presets))
+	for name := range presets {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// ExpandPresets replaces every endpoint naming a preset with the preset's
+// endpoints. Unknown presets are dropped; Validate reports them.
+func ExpandPresets(endpoints []Endpoint) []Endpoint {
+	var expanded []Endpoint
+	for _, e := range endpoints {
+		if e.Preset == "" {
+			expanded = append(expanded, e)
+			continue
+		}
+		for _, p := range presets[e.Preset] {
+			if e.Timeout > 0 {
+				p.Timeout = e.Timeout
+			}
+			if e.Priority != 0 {
+				p.Priority = e.Priority
+			}
+			if e.Family != "" && p.Family != "" && p.Family != e.Family {
+				continue
+			}
+			if p.Family == "" {
+				p.Family = e.Family
+			}
+			expanded = append(expanded, p)
+		}
+	}
+	return expanded
+}
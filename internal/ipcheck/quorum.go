@@ -0,0 +1,162 @@
+package ipcheck
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Mode selects how a Fetcher uses its endpoints.
+type Mode string
+
+const (
+	// ModeFirst tries the endpoints in order and returns the first answer.
+	ModeFirst Mode = "first"
+	// ModeQuorum queries every endpoint at once and only accepts an address
+	// reported by a quorum of them.
+	ModeQuorum Mode = "quorum"
+	// ModeRace starts the endpoints one after another without waiting for
+	// their answers and returns the first valid one.
+	ModeRace Mode = "race"
+)
+
+// ParseMode converts a configuration value into a Mode.
+func ParseMode(s string) (Mode, error) {
+	switch mode := Mode(strings.ToLower(strings.TrimSpace(s))); mode {
+	case ModeFirst, ModeQuorum, ModeRace:
+		return mode, nil
+	}
+	return "", fmt.Errorf("unknown detection mode %q", s)
+}
+
+// ErrNoConsensus is matched by the error of a quorum detection in which the
+// endpoints that answered did not agree. The detected address cannot be
+// trusted, so no DNS record should be changed.
+var ErrNoConsensus = errors.New("no consensus on public IP")
+
+// ConsensusError reports the answers of a quorum detection that did not
+// reach a quorum.
+type ConsensusError struct {
+	Family Family
+	Quorum int
+	// Votes maps each reported address to the endpoints reporting it.
+	Votes map[string][]string
+	// Failed maps each endpoint that did not answer to its error.
+	Failed map[string]error
+}
+
+func (e *ConsensusError) Error() string {
+	ips := make([]string, 0, len(e.Votes))
+	for ip := range e.Votes {
+		ips = append(ips, ip)
+	}
+	sort.Strings(ips)
+	parts := make([]string, len(ips))
+	for i, ip := range ips {
+		parts[i] = fmt.Sprintf("%s from %s", ip, strings.Join(e.Votes[ip], ", "))
+	}
+	return fmt.Sprintf("%v: need %d agreeing %s endpoints, got %s; %d failed",
+		ErrNoConsensus, e.Quorum, e.Family, strings.Join(parts, "; "), len(e.Failed))
+}
+
+// Is makes the error match ErrNoConsensus.
+func (e *ConsensusError) Is(target error) bool {
+	return target == ErrNoConsensus
+}
+
+// SetMode changes how the fetcher uses its endpoints.
+func (f *Fetcher) SetMode(mode Mode) {
+	f.mode = mode
+}
+
+// SetQuorum sets how many endpoints must agree in ModeQuorum. Zero, the
+// default, requires a majority of the endpoints that answer, and at least
+// two of them when more than one is configured.
+func (f *Fetcher) SetQuorum(n int) {
+	f.quorum = n
+}
+
+// minQuorumAnswers is the fewest answers a default quorum is reached with,
+// so a lone endpoint cannot decide the address when the others fail.
+const minQuorumAnswers = 2
+
+// quorumSize returns how many of the answered endpoints must agree. Failed
+// endpoints are left out rather than counted as dissent, so one that is down
+// does not block a quorum the others agree on.
+func (f *Fetcher) quorumSize(answered int) int {
+	if f.quorum > 0 {
+		return f.quorum
+	}
+	return max(answered/2+1, min(minQuorumAnswers, len(f.endpoints)))
+}
+
+// currentIPQuorum queries every endpoint in parallel and returns the address
+// reported by at least a quorum of them. Endpoints reporting another address
+// are logged. When too few endpoints answer to reach a quorum the error is a
+// plain failure; a quorum the answers could reach but did not is a
+// *ConsensusError.
+func (f *Fetcher) currentIPQuorum(ctx context.Context) (net.IP, error) {
+	type answer struct {
+		ip  net.IP
+		err error
+	}
+	results := make([]answer, len(f.endpoints))
+	var wg sync.WaitGroup
+	for i, endpoint := range f.endpoints {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			ip, err := f.fetch(ctx, endpoint)
+			results[i] = answer{ip, err}
+		}()
+	}
+	wg.Wait()
+
+	// Endpoints are recorded in configuration order so reports are stable.
+	votes := make(map[string][]string)
+	failed := make(map[string]error)
+	var errs []error
+	for i, endpoint := range f.endpoints {
+		a := results[i]
+		if a.err != nil {
+			failed[endpoint.URL] = a.err
+			errs = append(errs, fmt.Errorf("%s: %w", endpoint.URL, a.err))
+			continue
+		}
+		votes[a.ip.String()] = append(votes[a.ip.String()], endpoint.URL)
+	}
+	if len(votes) == 0 {
+		return nil, fmt.Errorf("all %s endpoints failed: %w", f.family, errors.Join(errs...))
+	}
+
+	answered := len(f.endpoints) - len(failed)
+	quorum := f.quorumSize(answered)
+	if answered < quorum {
+		return nil, fmt.Errorf("only %d of %d %s endpoints answered, need %d: %w", answered, len(f.endpoints), f.family, quorum, errors.Join(errs...))
+	}
+	var winner string
+	for ip, endpoints := range votes {
+		if len(endpoints) < quorum {
+			continue
+		}
+		if winner != "" {
+			// Two addresses reached a quorum smaller than a majority.
+			winner = ""
+			break
+		}
+		winner = ip
+	}
+	if winner == "" {
+		return nil, &ConsensusError{Family: f.family, Quorum: quorum, Votes: votes, Failed: failed}
+	}
+	for ip, endpoints := range votes {
+		if ip != winner {
+			f.logger.Warn("endpoints disagree with the public IP quorum", "family", f.family, "ip", winner, "reported", ip, "endpoints", endpoints)
+		}
+	}
+	return net.ParseIP(winner), nil
+}
@@ -0,0 +1,83 @@
+package ipcheck
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"time"
+)
+
+// SetStagger sets the delay between the starts of the endpoints in
+// ModeRace. Zero starts them all at once.
+func (f *Fetcher) SetStagger(d time.Duration) {
+	f.stagger = d
+}
+
+// currentIPRace starts the endpoints in priority order, one every stagger,
+// and returns the first valid answer, cancelling the requests still running.
+// As in happy eyeballs, a failure starts the next endpoint without waiting.
+func (f *Fetcher) currentIPRace(ctx context.Context) (net.IP, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	type answer struct {
+		i   int
+		ip  net.IP
+		err error
+	}
+	// Buffered so losing requests finish without a reader.
+	answers := make(chan answer, len(f.endpoints))
+	next, running := 0, 0
+	start := func() {
+		i := next
+		next++
+		running++
+		go func() {
+			ip, err := f.fetch(ctx, f.endpoints[i])
+			answers <- answer{i, ip, err}
+		}()
+	}
+
+	start()
+	for f.stagger <= 0 && next < len(f.endpoints) {
+		start()
+	}
+	timer := time.NewTimer(f.stagger)
+	defer timer.Stop()
+	restart := func() {
+		if !timer.Stop() {
+			select {
+			case <-timer.C:
+			default:
+			}
+		}
+		timer.Reset(f.stagger)
+	}
+
+	errs := make([]error, len(f.endpoints))
+	for running > 0 {
+		var timerC <-chan time.Time
+		if next < len(f.endpoints) {
+			timerC = timer.C
+		}
+		select {
+		case a := <-answers:
+			running--
+			if a.err == nil {
+				return a.ip, nil
+			}
+			errs[a.i] = fmt.Errorf("%s: %w", f.endpoints[a.i].URL, a.err)
+			if next < len(f.endpoints) {
+				start()
+				restart()
+			}
+		case <-timerC:
+			start()
+			restart()
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+	return nil, fmt.Errorf("all %s endpoints failed: %w", f.family, errors.Join(errs...))
+}
@@ -0,0 +1,213 @@
+package ipcheck
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// stunScheme marks endpoints answered by a STUN server, e.g.
+// "stun://stun.l.google.com:19302".
+const stunScheme = "stun"
+
+// STUN message constants from RFC 5389.
+const (
+	stunMagicCookie      = 0x2112A442
+	stunBindingRequest   = 0x0001
+	stunBindingSuccess   = 0x0101
+	stunBindingError     = 0x0111
+	stunHeaderLen        = 20
+	stunMappedAddress    = 0x0001
+	stunXORMappedAddress = 0x0020
+	stunErrorCode        = 0x0009
+	stunDefaultPort      = "3478"
+	// stunRTO is the initial retransmission timeout; it doubles after every
+	// unanswered request.
+	stunRTO = 500 * time.Millisecond
+)
+
+func isSTUN(rawURL string) bool {
+	return strings.HasPrefix(strings.ToLower(rawURL), stunScheme+"://")
+}
+
+// parseSTUNServer returns the host:port of a STUN endpoint URL, with port
+// 3478 unless given.
+func parseSTUNServer(rawURL string) (string, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return "", err
+	}
+	if u.Hostname() == "" {
+		return "", fmt.Errorf("no STUN server in %s", rawURL)
+	}
+	if strings.Trim(u.Path, "/") != "" || u.RawQuery != "" {
+		return "", fmt.Errorf("STUN endpoint %s takes no path or parameters", rawURL)
+	}
+	port := u.Port()
+	if port == "" {
+		port = stunDefaultPort
+	}
+	return net.JoinHostPort(u.Hostname(), port), nil
+}
+
+// bind sends a STUN Binding request over UDP in the fetcher's family and
+// returns the mapped address the server reports. Unanswered requests are
+// retransmitted until the context ends.
+func (f *Fetcher) bind(ctx context.Context, e Endpoint) (net.IP, error) {
+	server, err := parseSTUNServer(e.URL)
+	if err != nil {
+		return nil, err
+	}
+	network := "udp4"
+	if f.family == IPv6 {
+		network = "udp6"
+	}
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, network, server)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	stop := context.AfterFunc(ctx, func() { conn.SetDeadline(time.Now()) })
+	defer stop()
+
+	req := make([]byte, stunHeaderLen)
+	binary.BigEndian.PutUint16(req[0:], stunBindingRequest)
+	binary.BigEndian.PutUint32(req[4:], stunMagicCookie)
+	if _, err := rand.Read(req[8:stunHeaderLen]); err != nil {
+		return nil, err
+	}
+
+	buf := make([]byte, 1500)
+	for rto := stunRTO; ; rto *= 2 {
+		if _, err := conn.Write(req); err != nil {
+			return nil, err
+		}
+		retransmit := time.Now().Add(rto)
+		if deadline, ok := ctx.Deadline(); ok && deadline.Before(retransmit) {
+			retransmit = deadline
+		}
+		conn.SetReadDeadline(retransmit)
+		for {
+			n, err := conn.Read(buf)
+			if ctx.Err() != nil {
+				return nil, fmt.Errorf("binding request to %s: %w", e.URL, ctx.Err())
+			}
+			var netErr net.Error
+			if errors.As(err, &netErr) && netErr.Timeout() {
+				break
+			}
+			if err != nil {
+				return nil, err
+			}
+			ip, err := parseBindingResponse(buf[:n], req[8:stunHeaderLen])
+			if errors.Is(err, errStrayMessage) {
+				continue
+			}
+			if err != nil {
+				return nil, fmt.Errorf("binding response from %s: %w", e.URL, err)
+			}
+			return ip, nil
+		}
+	}
+}
+
+// errStrayMessage reports a datagram that does not answer our request.
+var errStrayMessage = errors.New("not a response to the request")
+
+// parseBindingResponse reads the mapped address from a Binding response
+// carrying the transaction ID txID. XOR-MAPPED-ADDRESS is preferred over the
+// MAPPED-ADDRESS sent by servers predating RFC 5389.
+func parseBindingResponse(msg, txID []byte) (net.IP, error) {
+	if len(msg) < stunHeaderLen || binary.BigEndian.Uint32(msg[4:]) != stunMagicCookie || string(msg[8:stunHeaderLen]) != string(txID) {
+		return nil, errStrayMessage
+	}
+	length := int(binary.BigEndian.Uint16(msg[2:]))
+	if stunHeaderLen+length > len(msg) {
+		return nil, errors.New("truncated message")
+	}
+	typ := binary.BigEndian.Uint16(msg[0:])
+	if typ != stunBindingSuccess && typ != stunBindingError {
+		return nil, errStrayMessage
+	}
+
+	var mapped net.IP
+	attrs := msg[stunHeaderLen : stunHeaderLen+length]
+	for len(attrs) >= 4 {
+		attrType := binary.BigEndian.Uint16(attrs[0:])
+		attrLen := int(binary.BigEndian.Uint16(attrs[2:]))
+		if 4+attrLen > len(attrs) {
+			return nil, errors.New("truncated attribute")
+		}
+		value := attrs[4 : 4+attrLen]
+		switch attrType {
+		case stunXORMappedAddress:
+			ip, err := stunAddress(value, msg[4:stunHeaderLen])
+			if err != nil {
+				return nil, err
+			}
+			if typ == stunBindingSuccess {
+				return ip, nil
+			}
+		case stunMappedAddress:
+			ip, err := stunAddress(value, nil)
+			if err != nil {
+				return nil, err
+			}
+			mapped = ip
+		case stunErrorCode:
+			if typ == stunBindingError && len(value) >= 4 {
+				code := int(value[2]&0x07)*100 + int(value[3])
+				return nil, fmt.Errorf("error %d %s", code, value[4:])
+			}
+		}
+		// Attributes are padded to a multiple of four bytes.
+		next := 4 + (attrLen+3)&^3
+		if next > len(attrs) {
+			break
+		}
+		attrs = attrs[next:]
+	}
+	if typ == stunBindingError {
+		return nil, errors.New("binding request failed")
+	}
+	if mapped == nil {
+		return nil, errors.New("no mapped address")
+	}
+	return mapped, nil
+}
+
+// stunAddress decodes an address attribute. With key, the magic cookie
+// followed by the transaction ID, the address is XORed as in
+// XOR-MAPPED-ADDRESS.
+func stunAddress(value, key []byte) (net.IP, error) {
+	if len(value) < 4 {
+		return nil, errors.New("invalid address attribute")
+	}
+	var size int
+	switch value[1] {
+	case 0x01:
+		size = net.IPv4len
+	case 0x02:
+		size = net.IPv6len
+	default:
+		return nil, fmt.Errorf("unknown address family %#x", value[1])
+	}
+	if len(value) != 4+size {
+		return nil, errors.New("invalid address attribute")
+	}
+	ip := make(net.IP, size)
+	copy(ip, value[4:])
+	for i := range key {
+		if i < size {
+			ip[i] ^= key[i]
+		}
+	}
+	return ip, nil
+}
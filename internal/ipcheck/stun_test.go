@@ -0,0 +1,148 @@
+package ipcheck
+
+import (
+	"context"
+	"encoding/binary"
+	"net"
+	"net/http"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// stunServer answers Binding requests on addr ("127.0.0.1:0" or "[::1]:0")
+// with mapped in an XOR-MAPPED-ADDRESS attribute, or with the sender's
+// address when mapped is nil. The first drop requests are ignored. It
+// returns the server's address.
+func stunServer(t *testing.T, addr string, mapped net.IP, drop int32) string {
+	t.Helper()
+	conn, err := net.ListenPacket("udp", addr)
+	if err != nil {
+		t.Skipf("cannot listen on %s: %v", addr, err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	var received atomic.Int32
+	go func() {
+		buf := make([]byte, 1500)
+		for {
+			n, from, err := conn.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			if n < stunHeaderLen || binary.BigEndian.Uint16(buf) != stunBindingRequest || received.Add(1) <= drop {
+				continue
+			}
+			ip := mapped
+			if ip == nil {
+				ip = from.(*net.UDPAddr).IP
+			}
+			// A stray message with another transaction ID comes first.
+			stray := stunResponse(buf[:stunHeaderLen], ip)
+			stray[19] ^= 0xff
+			conn.WriteTo(stray, from)
+			conn.WriteTo(stunResponse(buf[:stunHeaderLen], ip), from)
+		}
+	}()
+	return conn.LocalAddr().String()
+}
+
+// stunResponse builds a Binding success response to req carrying a SOFTWARE
+// attribute, to exercise padding, and ip as XOR-MAPPED-ADDRESS.
+func stunResponse(req []byte, ip net.IP) []byte {
+	family, addr := byte(0x01), ip.To4()
+	if addr == nil {
+		family, addr = 0x02, ip.To16()
+	}
+	key := req[4:stunHeaderLen]
+	value := []byte{0, family, 0x21 ^ 0x12, 0x34 ^ 0xA4}
+	for i, b := range addr {
+		value = append(value, b^key[i])
+	}
+	msg := append([]byte(nil), req...)
+	binary.BigEndian.PutUint16(msg, stunBindingSuccess)
+	msg = append(msg, 0x80, 0x22, 0, 5, 't', 'e', 's', 't', 's', 0, 0, 0)
+	msg = binary.BigEndian.AppendUint16(msg, stunXORMappedAddress)
+	msg = binary.BigEndian.AppendUint16(msg, uint16(len(value)))
+	msg = append(msg, value...)
+	binary.BigEndian.PutUint16(msg[2:], uint16(len(msg)-stunHeaderLen))
+	return msg
+}
+
+func TestBind(t *testing.T) {
+	tests := []struct {
+		family Family
+		listen string
+		mapped string
+	}{
+		{IPv4, "127.0.0.1:0", "203.0.113.9"},
+		{IPv6, "[::1]:0", "2001:db8::9"},
+	}
+	for _, tt := range tests {
+		t.Run(string(tt.family), func(t *testing.T) {
+			addr := stunServer(t, tt.listen, net.ParseIP(tt.mapped), 0)
+			f := NewFetcher(tt.family, http.DefaultClient, []string{"stun://" + addr}, nil)
+			ip, err := f.CurrentIP(context.Background())
+			if err != nil || !ip.Equal(net.ParseIP(tt.mapped)) {
+				t.Fatalf("expected the mapped address, got %v, %v", ip, err)
+			}
+		})
+	}
+}
+
+func TestBindRetransmits(t *testing.T) {
+	addr := stunServer(t, "127.0.0.1:0", nil, 1)
+	f := NewFetcher(IPv4, http.DefaultClient, []string{"stun://" + addr}, nil)
+	ip, err := f.CurrentIP(context.Background())
+	if err != nil || !ip.Equal(net.ParseIP("127.0.0.1")) {
+		t.Fatalf("expected the sender's address after a retransmission, got %v, %v", ip, err)
+	}
+
+	silent := stunServer(t, "127.0.0.1:0", nil, 1<<30)
+	e, err := ParseEndpoint("stun://" + silent + " timeout=100ms")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	f = NewFetcher(IPv4, http.DefaultClient, nil, nil)
+	f.SetEndpoints([]Endpoint{e})
+	start := time.Now()
+	if _, err := f.CurrentIP(context.Background()); err == nil {
+		t.Fatalf("expected an unanswered request to fail")
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Fatalf("timeout not applied, took %s", elapsed)
+	}
+}
+
+func TestParseBindingResponse(t *testing.T) {
+	txID := []byte("0123456789ab")
+	req := make([]byte, stunHeaderLen)
+	binary.BigEndian.PutUint16(req, stunBindingRequest)
+	binary.BigEndian.PutUint32(req[4:], stunMagicCookie)
+	copy(req[8:], txID)
+
+	// Servers predating RFC 5389 only send MAPPED-ADDRESS.
+	legacy := append([]byte(nil), req...)
+	binary.BigEndian.PutUint16(legacy, stunBindingSuccess)
+	legacy = append(legacy, 0, 0x01, 0, 8, 0, 0x01, 0x12, 0x34, 198, 51, 100, 7)
+	binary.BigEndian.PutUint16(legacy[2:], 12)
+	if ip, err := parseBindingResponse(legacy, txID); err != nil || !ip.Equal(net.ParseIP("198.51.100.7")) {
+		t.Fatalf("expected the mapped address, got %v, %v", ip, err)
+	}
+
+	failed := append([]byte(nil), req...)
+	binary.BigEndian.PutUint16(failed, stunBindingError)
+	failed = append(failed, 0, 0x09, 0, 8, 0, 0, 4, 20, 'b', 'u', 's', 'y')
+	binary.BigEndian.PutUint16(failed[2:], 12)
+	if _, err := parseBindingResponse(failed, txID); err == nil || err.Error() != "error 420 busy" {
+		t.Fatalf("expected the error response, got %v", err)
+	}
+
+	if _, err := parseBindingResponse(legacy[:stunHeaderLen+6], txID); err == nil {
+		t.Fatalf("expected a truncated message to be rejected")
+	}
+	for _, bad := range []string{"stun://", "stun://stun.example.com/path", "stun://stun.example.com parser=json path=ip"} {
+		if _, err := ParseEndpoint(bad); err == nil {
+			t.Errorf("expected %q to be rejected", bad)
+		}
+	}
+}
@@ -0,0 +1,122 @@
+// Package provider defines the interface the updater uses to read and write
+// DNS records, independent of the service hosting them.
+package provider
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// ErrConflict is returned by UpsertRecords when the provider refuses to
+// overwrite existing records in place. Callers may fall back to deleting and
+// recreating the records.
+var ErrConflict = errors.New("conflicting records")
+
+// Errors providers wrap or match so callers can react to a failure without
+// knowing the provider: ErrUnauthorized means retrying is pointless until the
+// credentials are fixed, ErrNotFound that the domain or record does not exist
+// and ErrRateLimited that the request may succeed later.
+var (
+	ErrUnauthorized = errors.New("unauthorized")
+	ErrNotFound     = errors.New("not found")
+	ErrRateLimited  = errors.New("rate limited")
+)
+
+// PartialError is returned by FetchRecords when some domains could not be
+// read. The records of every other domain are returned alongside it.
+type PartialError struct {
+	// Errs maps each failed domain to its error.
+	Errs map[string]error
+}
+
+// Domains returns the failed domains, sorted.
+func (e *PartialError) Domains() []string {
+	domains := make([]string, 0, len(e.Errs))
+	for domain := range e.Errs {
+		domains = append(domains, domain)
+	}
+	sort.Strings(domains)
+	return domains
+}
+
+func (e *PartialError) Error() string {
+	parts := make([]string, 0, len(e.Errs))
+	for _, domain := range e.Domains() {
+		parts = append(parts, fmt.Sprintf("%s: %v", domain, e.Errs[domain]))
+	}
+	return fmt.Sprintf("%d domains could not be read: %s", len(e.Errs), strings.Join(parts, "; "))
+}
+
+// Unwrap returns the error of every failed domain.
+func (e *PartialError) Unwrap() []error {
+	errs := make([]error, 0, len(e.Errs))
+	for _, domain := range e.Domains() {
+		errs = append(errs, e.Errs[domain])
+	}
+	return errs
+}
+
+// Record is a DNS record as seen by a provider. Name is relative to Domain,
+// with "@" for the apex, and Content holds the record value in presentation
+// format (the address for A and AAAA records).
+type Record struct {
+	Domain  string `json:"domain"`
+	Name    string `json:"name"`
+	Type    string `json:"type"`
+	Content string `json:"content"`
+	TTL     int    `json:"ttl"`
+}
+
+// Capabilities describes optional provider behaviour.
+type Capabilities struct {
+	// InPlaceUpdate reports that UpsertRecords replaces existing records of
+	// the same type and name, so they need not be deleted first.
+	InPlaceUpdate bool
+	// DeleteByValue reports that DeleteRecords removes only records whose
+	// content matches, rather than every record with the same type and name.
+	DeleteByValue bool
+	// RecordTypes lists the record types the provider can write. An empty
+	// list means any type.
+	RecordTypes []string
+	// MinTTL and MaxTTL bound the TTLs the provider stores; a TTL outside
+	// them is clamped on write. Zero means no bound.
+	MinTTL int
+	MaxTTL int
+}
+
+// Supports reports whether the provider can write records of type t.
+func (c Capabilities) Supports(t string) bool {
+	if len(c.RecordTypes) == 0 {
+		return true
+	}
+	for _, supported := range c.RecordTypes {
+		if supported == t {
+			return true
+		}
+	}
+	return false
+}
+
+// SupportsTTL reports whether the provider stores ttl as given.
+func (c Capabilities) SupportsTTL(ttl int) bool {
+	return (c.MinTTL == 0 || ttl >= c.MinTTL) && (c.MaxTTL == 0 || ttl <= c.MaxTTL)
+}
+
+// DNSProvider lists and changes the DNS records of the domains it manages.
+type DNSProvider interface {
+	// Name identifies the provider in logs and configuration.
+	Name() string
+	// Capabilities reports the optional behaviour the provider supports.
+	Capabilities() Capabilities
+	// FetchRecords returns every record of every domain the provider manages.
+	// It may return the records it could read together with a *PartialError.
+	FetchRecords(ctx context.Context) ([]Record, error)
+	// UpsertRecords creates the records of a domain, replacing existing
+	// records of the same type and name when InPlaceUpdate is supported.
+	UpsertRecords(ctx context.Context, domain string, records []Record) error
+	// DeleteRecords removes records from a domain.
+	DeleteRecords(ctx context.Context, domain string, records []Record) error
+}
@@ -0,0 +1,447 @@
+// Package rfc2136 implements a DNS provider that changes records on an
+// authoritative server with RFC 2136 UPDATE messages, authenticated with
+// TSIG. Current zone contents are read with a zone transfer, or by querying
+// a configured list of names when transfers are refused.
+package rfc2136
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/miekg/dns"
+
+	"github.com/erkki/dnsupdater/internal/provider"
+)
+
+const (
+	defaultTimeout = 10 * time.Second
+	defaultTTL     = 300
+	tsigFudge      = 300
+)
+
+// TSIGKey is a shared secret used to sign requests and verify responses.
+type TSIGKey struct {
+	// Name is the key name as configured on the server.
+	Name string
+	// Algorithm is an HMAC algorithm such as "hmac-sha256".
+	Algorithm string
+	Secret    []byte
+}
+
+// tsigAlgorithms are the TSIG algorithms accepted in TSIGKey.
+var tsigAlgorithms = []string{dns.HmacSHA1, dns.HmacSHA224, dns.HmacSHA256, dns.HmacSHA384, dns.HmacSHA512}
+
+// Config configures a Provider.
+type Config struct {
+	// Server is the authoritative server as host or host:port.
+	Server string
+	// Zones lists the zones the provider manages.
+	Zones []string
+	// Key signs every request. A nil key sends unsigned messages.
+	Key *TSIGKey
+	// Transport is "udp" (default) or "tcp" for updates and queries. Zone
+	// transfers always use TCP.
+	Transport string
+	// Names are queried for A and AAAA records when a zone transfer is
+	// refused. They are relative to each zone, with "@" for the apex.
+	Names []string
+	// Timeout bounds each exchange with the server.
+	Timeout time.Duration
+}
+
+// Provider sends dynamic updates to an authoritative DNS server.
+type Provider struct {
+	cfg Config
+	// secrets maps the canonical key name to its base64 secret, as the dns
+	// package expects.
+	secrets map[string]string
+}
+
+var _ provider.DNSProvider = (*Provider)(nil)
+
+// New validates cfg and returns a Provider.
+func New(cfg Config) (*Provider, error) {
+	if cfg.Server == "" {
+		return nil, errors.New("rfc2136: server must be set")
+	}
+	if _, _, err := net.SplitHostPort(cfg.Server); err != nil {
+		cfg.Server = net.JoinHostPort(cfg.Server, "53")
+	}
+	if len(cfg.Zones) == 0 {
+		return nil, errors.New("rfc2136: at least one zone must be set")
+	}
+	switch cfg.Transport {
+	case "":
+		cfg.Transport = "udp"
+	case "udp", "tcp":
+	default:
+		return nil, fmt.Errorf("rfc2136: invalid transport %q", cfg.Transport)
+	}
+	if cfg.Timeout <= 0 {
+		cfg.Timeout = defaultTimeout
+	}
+	zones := make([]string, len(cfg.Zones))
+	for i, zone := range cfg.Zones {
+		zones[i] = strings.ToLower(strings.TrimSuffix(zone, "."))
+	}
+	cfg.Zones = zones
+
+	p := &Provider{cfg: cfg}
+	if cfg.Key != nil {
+		key := *cfg.Key
+		key.Name = dns.CanonicalName(key.Name)
+		key.Algorithm = dns.CanonicalName(key.Algorithm)
+		if !slices.Contains(tsigAlgorithms, key.Algorithm) {
+			return nil, fmt.Errorf("rfc2136: unsupported TSIG algorithm %q", cfg.Key.Algorithm)
+		}
+		if len(key.Secret) == 0 {
+			return nil, errors.New("rfc2136: TSIG secret must be set")
+		}
+		p.cfg.Key = &key
+		p.secrets = map[string]string{key.Name: base64.StdEncoding.EncodeToString(key.Secret)}
+	}
+	return p, nil
+}
+
+// Name implements provider.DNSProvider.
+func (p *Provider) Name() string {
+	return "rfc2136"
+}
+
+// Capabilities implements provider.DNSProvider. A single UPDATE message
+// deletes and re-adds an RRset atomically, and deletions name exact records.
+func (p *Provider) Capabilities() provider.Capabilities {
+	return provider.Capabilities{
+		InPlaceUpdate: true,
+		DeleteByValue: true,
+		RecordTypes:   []string{"A", "AAAA", "CNAME", "MX", "NS", "PTR", "TXT"},
+	}
+}
+
+// FetchRecords reads every configured zone, via AXFR when the server allows
+// it and by querying the configured names otherwise.
+func (p *Provider) FetchRecords(ctx context.Context) ([]provider.Record, error) {
+	var records []provider.Record
+	for _, zone := range p.cfg.Zones {
+		recs, err := p.transfer(ctx, zone)
+		if err != nil {
+			if len(p.cfg.Names) == 0 {
+				return nil, fmt.Errorf("rfc2136: transfer of %s failed and no names are configured to query: %w", zone, err)
+			}
+			var qerr error
+			recs, qerr = p.query(ctx, zone)
+			if qerr != nil {
+				return nil, fmt.Errorf("rfc2136: transfer of %s failed (%v), query failed: %w", zone, err, qerr)
+			}
+		}
+		records = append(records, recs...)
+	}
+	return records, nil
+}
+
+// UpsertRecords replaces the RRsets of the given records in one UPDATE.
+func (p *Provider) UpsertRecords(ctx context.Context, domain string, records []provider.Record) error {
+	if len(records) == 0 {
+		return nil
+	}
+	zone, err := p.zone(domain)
+	if err != nil {
+		return err
+	}
+
+	msg := new(dns.Msg)
+	msg.SetUpdate(dns.Fqdn(zone))
+	var sets, adds []dns.RR
+	seen := make(map[string]bool)
+	for _, record := range records {
+		rr, err := newRR(zone, record)
+		if err != nil {
+			return err
+		}
+		key := rr.Header().Name + " " + record.Type
+		if !seen[key] {
+			seen[key] = true
+			sets = append(sets, rr)
+		}
+		adds = append(adds, rr)
+	}
+	msg.RemoveRRset(sets)
+	msg.Insert(adds)
+	return p.update(ctx, zone, msg)
+}
+
+// DeleteRecords removes exactly the given records in one UPDATE.
+func (p *Provider) DeleteRecords(ctx context.Context, domain string, records []provider.Record) error {
+	if len(records) == 0 {
+		return nil
+	}
+	zone, err := p.zone(domain)
+	if err != nil {
+		return err
+	}
+
+	msg := new(dns.Msg)
+	msg.SetUpdate(dns.Fqdn(zone))
+	deletes := make([]dns.RR, 0, len(records))
+	for _, record := range records {
+		rr, err := newRR(zone, record)
+		if err != nil {
+			return err
+		}
+		deletes = append(deletes, rr)
+	}
+	msg.Remove(deletes)
+	return p.update(ctx, zone, msg)
+}
+
+func (p *Provider) update(ctx context.Context, zone string, msg *dns.Msg) error {
+	resp, err := p.exchange(ctx, msg)
+	if err != nil {
+		return fmt.Errorf("rfc2136: update of %s: %w", zone, err)
+	}
+	switch resp.Rcode {
+	case dns.RcodeSuccess:
+	case dns.RcodeNotAuth, dns.RcodeRefused:
+		return fmt.Errorf("rfc2136: update of %s refused: %s: %w", zone, dns.RcodeToString[resp.Rcode], provider.ErrUnauthorized)
+	default:
+		return fmt.Errorf("rfc2136: update of %s refused: %s", zone, dns.RcodeToString[resp.Rcode])
+	}
+	return nil
+}
+
+// transfer reads a zone with AXFR, signed with the key when one is set.
+func (p *Provider) transfer(ctx context.Context, zone string) ([]provider.Record, error) {
+	msg := new(dns.Msg)
+	msg.SetAxfr(dns.Fqdn(zone))
+	p.sign(msg)
+	t := &dns.Transfer{DialTimeout: p.cfg.Timeout, ReadTimeout: p.cfg.Timeout, WriteTimeout: p.cfg.Timeout, TsigSecret: p.secrets}
+	envelopes, err := t.In(msg, p.cfg.Server)
+	if err != nil {
+		return nil, err
+	}
+	// Transfer does not watch ctx; closing the connection ends it early.
+	stop := context.AfterFunc(ctx, func() { t.Close() })
+	defer stop()
+
+	var records []provider.Record
+	var failed error
+	for env := range envelopes {
+		if env.Error != nil {
+			if failed == nil {
+				failed = env.Error
+			}
+			continue
+		}
+		for _, rr := range env.RR {
+			if rr.Header().Rrtype == dns.TypeSOA {
+				continue
+			}
+			if record, ok := toRecord(zone, rr); ok {
+				records = append(records, record)
+			}
+		}
+	}
+	if ctx.Err() != nil {
+		return nil, ctx.Err()
+	}
+	if failed != nil {
+		return nil, failed
+	}
+	return records, nil
+}
+
+// query looks up A and AAAA records of the configured names.
+func (p *Provider) query(ctx context.Context, zone string) ([]provider.Record, error) {
+	var records []provider.Record
+	for _, name := range p.cfg.Names {
+		fqdn := qualify(zone, name)
+		for _, t := range []uint16{dns.TypeA, dns.TypeAAAA} {
+			msg := new(dns.Msg)
+			msg.SetQuestion(fqdn, t)
+			resp, err := p.exchange(ctx, msg)
+			if err != nil {
+				return nil, err
+			}
+			if resp.Rcode == dns.RcodeNameError {
+				continue
+			}
+			if resp.Rcode != dns.RcodeSuccess {
+				return nil, fmt.Errorf("query %s %s: %s", fqdn, dns.TypeToString[t], dns.RcodeToString[resp.Rcode])
+			}
+			for _, rr := range resp.Answer {
+				if rr.Header().Rrtype != t || !strings.EqualFold(rr.Header().Name, fqdn) {
+					continue
+				}
+				if record, ok := toRecord(zone, rr); ok {
+					records = append(records, record)
+				}
+			}
+		}
+	}
+	return records, nil
+}
+
+// exchange signs msg, sends it and returns the response, whose signature the
+// dns package has verified when present.
+func (p *Provider) exchange(ctx context.Context, msg *dns.Msg) (*dns.Msg, error) {
+	ctx, cancel := context.WithTimeout(ctx, p.cfg.Timeout)
+	defer cancel()
+
+	p.sign(msg)
+	c := &dns.Client{Net: p.cfg.Transport, Timeout: p.cfg.Timeout, TsigSecret: p.secrets}
+	resp, _, err := c.ExchangeContext(ctx, msg, p.cfg.Server)
+	if err != nil {
+		return nil, err
+	}
+	// Servers may answer NOTAUTH unsigned when they do not know the key, but
+	// a successful answer to a signed request must be signed.
+	if p.cfg.Key != nil && resp.IsTsig() == nil && resp.Rcode == dns.RcodeSuccess {
+		return nil, errors.New("unsigned response to a signed request")
+	}
+	return resp, nil
+}
+
+func (p *Provider) sign(msg *dns.Msg) {
+	if p.cfg.Key != nil {
+		msg.SetTsig(p.cfg.Key.Name, p.cfg.Key.Algorithm, tsigFudge, time.Now().Unix())
+	}
+}
+
+// zone returns the configured zone matching domain.
+func (p *Provider) zone(domain string) (string, error) {
+	domain = strings.ToLower(strings.TrimSuffix(domain, "."))
+	for _, zone := range p.cfg.Zones {
+		if zone == domain {
+			return zone, nil
+		}
+	}
+	return "", fmt.Errorf("rfc2136: %s is not a configured zone", domain)
+}
+
+// newRR builds the record in zone from its presentation-format content.
+func newRR(zone string, record provider.Record) (dns.RR, error) {
+	t, ok := dns.StringToType[strings.ToUpper(record.Type)]
+	if !ok {
+		return nil, fmt.Errorf("rfc2136: unknown record type %q", record.Type)
+	}
+	ttl := record.TTL
+	if ttl <= 0 {
+		ttl = defaultTTL
+	}
+	hdr := dns.RR_Header{Name: qualify(zone, record.Name), Rrtype: t, Class: dns.ClassINET, Ttl: uint32(ttl)}
+	content := record.Content
+	invalid := func() (dns.RR, error) {
+		return nil, fmt.Errorf("rfc2136: %s %s: invalid content %q", record.Name, record.Type, content)
+	}
+	switch t {
+	case dns.TypeA:
+		ip := net.ParseIP(content).To4()
+		if ip == nil {
+			return invalid()
+		}
+		return &dns.A{Hdr: hdr, A: ip}, nil
+	case dns.TypeAAAA:
+		ip := net.ParseIP(content)
+		if ip == nil || ip.To4() != nil {
+			return invalid()
+		}
+		return &dns.AAAA{Hdr: hdr, AAAA: ip}, nil
+	case dns.TypeCNAME, dns.TypeNS, dns.TypePTR:
+		if _, ok := dns.IsDomainName(content); !ok {
+			return invalid()
+		}
+		target := dns.Fqdn(content)
+		switch t {
+		case dns.TypeCNAME:
+			return &dns.CNAME{Hdr: hdr, Target: target}, nil
+		case dns.TypeNS:
+			return &dns.NS{Hdr: hdr, Ns: target}, nil
+		default:
+			return &dns.PTR{Hdr: hdr, Ptr: target}, nil
+		}
+	case dns.TypeMX:
+		fields := strings.Fields(content)
+		if len(fields) != 2 {
+			return invalid()
+		}
+		pref, err := strconv.ParseUint(fields[0], 10, 16)
+		if _, ok := dns.IsDomainName(fields[1]); err != nil || !ok {
+			return invalid()
+		}
+		return &dns.MX{Hdr: hdr, Preference: uint16(pref), Mx: dns.Fqdn(fields[1])}, nil
+	case dns.TypeTXT:
+		return &dns.TXT{Hdr: hdr, Txt: splitTXT(content)}, nil
+	default:
+		return nil, fmt.Errorf("rfc2136: unsupported record type %s", record.Type)
+	}
+}
+
+// splitTXT splits content into character-strings of at most 255 bytes.
+func splitTXT(content string) []string {
+	var strs []string
+	for len(content) > 255 {
+		strs = append(strs, content[:255])
+		content = content[255:]
+	}
+	return append(strs, content)
+}
+
+// qualify turns a zone-relative name into a fully qualified one.
+func qualify(zone, name string) string {
+	switch {
+	case name == "" || name == "@":
+		return dns.Fqdn(zone)
+	case strings.HasSuffix(name, "."):
+		return strings.ToLower(name)
+	default:
+		return strings.ToLower(name) + "." + dns.Fqdn(zone)
+	}
+}
+
+// toRecord converts rr to a zone-relative record. Types without a
+// presentation form here are skipped.
+func toRecord(zone string, rr dns.RR) (provider.Record, bool) {
+	var content string
+	switch rr := rr.(type) {
+	case *dns.A:
+		content = rr.A.String()
+	case *dns.AAAA:
+		content = rr.AAAA.String()
+	case *dns.CNAME:
+		content = rr.Target
+	case *dns.NS:
+		content = rr.Ns
+	case *dns.PTR:
+		content = rr.Ptr
+	case *dns.MX:
+		content = fmt.Sprintf("%d %s", rr.Preference, rr.Mx)
+	case *dns.TXT:
+		content = strings.Join(rr.Txt, "")
+	default:
+		return provider.Record{}, false
+	}
+	hdr := rr.Header()
+	name := strings.ToLower(strings.TrimSuffix(hdr.Name, "."))
+	switch {
+	case name == zone:
+		name = "@"
+	case strings.HasSuffix(name, "."+zone):
+		name = strings.TrimSuffix(name, "."+zone)
+	default:
+		return provider.Record{}, false
+	}
+	return provider.Record{
+		Domain:  zone,
+		Name:    name,
+		Type:    dns.TypeToString[hdr.Rrtype],
+		Content: content,
+		TTL:     int(hdr.Ttl),
+	}, true
+}
@@ -0,0 +1,270 @@
+package rfc2136
+
+import (
+	"context"
+	"encoding/base64"
+	"net"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+
+	"github.com/erkki/dnsupdater/internal/provider"
+)
+
+var testKey = &TSIGKey{Name: "updater.", Algorithm: "hmac-sha256", Secret: []byte("super-secret-tsig-key")}
+
+// testServer is a tiny authoritative server for one zone that requires TSIG,
+// answers queries, serves AXFR and applies RFC 2136 updates.
+type testServer struct {
+	zone      string
+	key       *TSIGKey
+	refuseXfr bool
+
+	mu      sync.Mutex
+	records []dns.RR
+	updates int
+
+	addr string
+}
+
+func newTestServer(t *testing.T, zone string, key *TSIGKey, records ...string) *testServer {
+	t.Helper()
+	s := &testServer{zone: dns.Fqdn(zone), key: key}
+	for _, r := range records {
+		fields := strings.SplitN(r, " ", 3)
+		rr, err := newRR(zone, provider.Record{Name: fields[0], Type: fields[1], Content: fields[2]})
+		if err != nil {
+			t.Fatalf("bad fixture %q: %v", r, err)
+		}
+		s.records = append(s.records, rr)
+	}
+
+	tcp, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.addr = tcp.Addr().String()
+	udp, err := net.ListenPacket("udp", s.addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	secrets := map[string]string{dns.CanonicalName(key.Name): base64.StdEncoding.EncodeToString(key.Secret)}
+	for _, srv := range []*dns.Server{
+		{Listener: tcp, TsigSecret: secrets, Handler: dns.HandlerFunc(s.serve), MsgAcceptFunc: acceptAll},
+		{PacketConn: udp, TsigSecret: secrets, Handler: dns.HandlerFunc(s.serve), MsgAcceptFunc: acceptAll},
+	} {
+		go srv.ActivateAndServe()
+		t.Cleanup(func() { srv.Shutdown() })
+	}
+	return s
+}
+
+// acceptAll lets UPDATE messages through, which the default accept func
+// rejects with NOTIMP.
+func acceptAll(dns.Header) dns.MsgAcceptAction { return dns.MsgAccept }
+
+func (s *testServer) serve(w dns.ResponseWriter, req *dns.Msg) {
+	resp := new(dns.Msg)
+	resp.SetReply(req)
+	resp.Authoritative = true
+	tsig := req.IsTsig()
+	if tsig == nil || w.TsigStatus() != nil {
+		resp.Rcode = dns.RcodeNotAuth
+		w.WriteMsg(resp)
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	q := req.Question[0]
+	switch {
+	case req.Opcode == dns.OpcodeUpdate:
+		s.updates++
+		s.applyUpdate(req.Ns)
+	case q.Qtype == dns.TypeAXFR:
+		if _, tcp := w.RemoteAddr().(*net.TCPAddr); !tcp || s.refuseXfr {
+			resp.Rcode = dns.RcodeRefused
+			break
+		}
+		s.transfer(w, req)
+		return
+	default:
+		found := false
+		for _, rr := range s.records {
+			if strings.EqualFold(rr.Header().Name, q.Name) {
+				found = true
+				if rr.Header().Rrtype == q.Qtype {
+					resp.Answer = append(resp.Answer, rr)
+				}
+			}
+		}
+		if !found {
+			resp.Rcode = dns.RcodeNameError
+		}
+	}
+	resp.SetTsig(tsig.Hdr.Name, tsig.Algorithm, tsig.Fudge, time.Now().Unix())
+	w.WriteMsg(resp)
+}
+
+// transfer sends the zone in two messages so multi-message TSIG is exercised.
+func (s *testServer) transfer(w dns.ResponseWriter, req *dns.Msg) {
+	soa := &dns.SOA{
+		Hdr: dns.RR_Header{Name: s.zone, Rrtype: dns.TypeSOA, Class: dns.ClassINET, Ttl: 3600},
+		Ns:  "ns1." + s.zone, Mbox: "admin." + s.zone,
+		Serial: 1, Refresh: 3600, Retry: 600, Expire: 86400, Minttl: 60,
+	}
+	ch := make(chan *dns.Envelope, 2)
+	ch <- &dns.Envelope{RR: append([]dns.RR{soa}, s.records...)}
+	ch <- &dns.Envelope{RR: []dns.RR{soa}}
+	close(ch)
+	new(dns.Transfer).Out(w, req, ch)
+}
+
+func (s *testServer) applyUpdate(updates []dns.RR) {
+	for _, u := range updates {
+		hdr := u.Header()
+		var kept []dns.RR
+		switch hdr.Class {
+		case dns.ClassANY:
+			for _, rr := range s.records {
+				if !(strings.EqualFold(rr.Header().Name, hdr.Name) && rr.Header().Rrtype == hdr.Rrtype) {
+					kept = append(kept, rr)
+				}
+			}
+			s.records = kept
+		case dns.ClassNONE:
+			match := dns.Copy(u)
+			match.Header().Class = dns.ClassINET
+			for _, rr := range s.records {
+				if !dns.IsDuplicate(rr, match) {
+					kept = append(kept, rr)
+				}
+			}
+			s.records = kept
+		default:
+			s.records = append(s.records, u)
+		}
+	}
+}
+
+func (s *testServer) contents() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var res []string
+	for _, rr := range s.records {
+		record, _ := toRecord(strings.TrimSuffix(s.zone, "."), rr)
+		res = append(res, strings.TrimSuffix(rr.Header().Name, ".")+" "+record.Type+" "+record.Content)
+	}
+	sort.Strings(res)
+	return res
+}
+
+func newTestProvider(t *testing.T, s *testServer, transport string, names ...string) *Provider {
+	t.Helper()
+	p, err := New(Config{
+		Server:    s.addr,
+		Zones:     []string{"example.com"},
+		Key:       testKey,
+		Transport: transport,
+		Names:     names,
+		Timeout:   2 * time.Second,
+	})
+	if err != nil {
+		t.Fatalf("new provider: %v", err)
+	}
+	return p
+}
+
+func TestFetchRecordsAXFR(t *testing.T) {
+	s := newTestServer(t, "example.com", testKey, "@ A 192.0.2.1", "home A 192.0.2.2", "home AAAA 2001:db8::2", "@ TXT hello")
+	p := newTestProvider(t, s, "udp")
+
+	records, err := p.FetchRecords(context.Background())
+	if err != nil {
+		t.Fatalf("fetch failed: %v", err)
+	}
+	var got []string
+	for _, r := range records {
+		got = append(got, r.Domain+" "+r.Name+" "+r.Type+" "+r.Content)
+	}
+	sort.Strings(got)
+	want := []string{
+		"example.com @ A 192.0.2.1",
+		"example.com @ TXT hello",
+		"example.com home A 192.0.2.2",
+		"example.com home AAAA 2001:db8::2",
+	}
+	if strings.Join(got, "|") != strings.Join(want, "|") {
+		t.Fatalf("unexpected records:\n got %v\nwant %v", got, want)
+	}
+}
+
+func TestFetchRecordsFallsBackToQuery(t *testing.T) {
+	s := newTestServer(t, "example.com", testKey, "@ A 192.0.2.1", "home A 192.0.2.2", "vps A 198.51.100.1")
+	s.refuseXfr = true
+	p := newTestProvider(t, s, "tcp", "home", "missing")
+
+	records, err := p.FetchRecords(context.Background())
+	if err != nil {
+		t.Fatalf("fetch failed: %v", err)
+	}
+	if len(records) != 1 || records[0].Name != "home" || records[0].Content != "192.0.2.2" {
+		t.Fatalf("expected only the queried name, got %+v", records)
+	}
+
+	p = newTestProvider(t, s, "udp")
+	if _, err := p.FetchRecords(context.Background()); err == nil {
+		t.Fatalf("expected error without names to fall back to")
+	}
+}
+
+func TestUpsertAndDeleteRecords(t *testing.T) {
+	s := newTestServer(t, "example.com", testKey, "home A 192.0.2.2", "rr A 192.0.2.10", "rr A 192.0.2.11")
+	p := newTestProvider(t, s, "udp")
+	ctx := context.Background()
+
+	err := p.UpsertRecords(ctx, "example.com", []provider.Record{
+		{Domain: "example.com", Name: "home", Type: "A", Content: "203.0.113.5", TTL: 60},
+		{Domain: "example.com", Name: "new", Type: "AAAA", Content: "2001:db8::5", TTL: 60},
+	})
+	if err != nil {
+		t.Fatalf("upsert failed: %v", err)
+	}
+	err = p.DeleteRecords(ctx, "example.com", []provider.Record{
+		{Domain: "example.com", Name: "rr", Type: "A", Content: "192.0.2.10"},
+	})
+	if err != nil {
+		t.Fatalf("delete failed: %v", err)
+	}
+
+	want := []string{
+		"home.example.com A 203.0.113.5",
+		"new.example.com AAAA 2001:db8::5",
+		"rr.example.com A 192.0.2.11",
+	}
+	if got := s.contents(); strings.Join(got, "|") != strings.Join(want, "|") {
+		t.Fatalf("unexpected zone:\n got %v\nwant %v", got, want)
+	}
+	if s.updates != 2 {
+		t.Fatalf("expected one UPDATE per call, got %d", s.updates)
+	}
+}
+
+func TestUpdateRejectedWithWrongKey(t *testing.T) {
+	s := newTestServer(t, "example.com", &TSIGKey{Name: "updater.", Algorithm: "hmac-sha256", Secret: []byte("other")})
+	p := newTestProvider(t, s, "udp")
+
+	err := p.UpsertRecords(context.Background(), "example.com", []provider.Record{
+		{Domain: "example.com", Name: "home", Type: "A", Content: "203.0.113.5"},
+	})
+	if err == nil || !strings.Contains(err.Error(), "NOTAUTH") {
+		t.Fatalf("expected NOTAUTH, got %v", err)
+	}
+	if err := p.UpsertRecords(context.Background(), "other.org", []provider.Record{{Name: "x", Type: "A", Content: "192.0.2.1"}}); err == nil {
+		t.Fatalf("expected unknown zone to be rejected")
+	}
+}
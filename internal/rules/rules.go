@@ -0,0 +1,236 @@
+// Package rules decides which DNS records the updater may change, based on
+// include and exclude rules matching a record's domain, name, type and
+// current content.
+package rules
+
+import (
+	"fmt"
+	"net"
+	"path"
+	"regexp"
+	"strings"
+
+	"github.com/erkki/dnsupdater/internal/provider"
+)
+
+// LastIP is a Content value matching records that still point at the IP the
+// updater last applied for their address family.
+const LastIP = "@last"
+
+// Rule matches records. Empty fields match anything; all set fields must
+// match. Domain and Name are shell-style globs, or regular expressions when
+// wrapped in slashes ("/^home-[0-9]+$/"), and match case-insensitively.
+// Name is relative to the domain, with "@" for the apex. Content is an IP
+// address, a CIDR prefix, LastIP, a glob or a /regex/.
+type Rule struct {
+	Domain  string `yaml:"domain"`
+	Name    string `yaml:"name"`
+	Type    string `yaml:"type"`
+	Content string `yaml:"content"`
+}
+
+// String formats the rule in the syntax accepted by Parse.
+func (r Rule) String() string {
+	var parts []string
+	for _, f := range []struct{ key, value string }{
+		{"domain", r.Domain}, {"name", r.Name}, {"type", r.Type}, {"content", r.Content},
+	} {
+		if f.value != "" {
+			parts = append(parts, f.key+"="+f.value)
+		}
+	}
+	if len(parts) == 0 {
+		return "*"
+	}
+	return strings.Join(parts, " ")
+}
+
+// Parse reads rules written as "key=value" pairs separated by spaces, with
+// rules separated by semicolons, e.g.
+// "domain=example.com name=home*; content=203.0.113.0/24".
+func Parse(s string) ([]Rule, error) {
+	var res []Rule
+	for _, raw := range strings.Split(s, ";") {
+		fields := strings.Fields(raw)
+		if len(fields) == 0 {
+			continue
+		}
+		var r Rule
+		for _, field := range fields {
+			key, value, ok := strings.Cut(field, "=")
+			if !ok || value == "" {
+				return nil, fmt.Errorf("invalid rule field %q, want key=value", field)
+			}
+			switch strings.ToLower(key) {
+			case "domain":
+				r.Domain = value
+			case "name":
+				r.Name = value
+			case "type":
+				r.Type = value
+			case "content":
+				r.Content = value
+			default:
+				return nil, fmt.Errorf("unknown rule field %q", key)
+			}
+		}
+		res = append(res, r)
+	}
+	return res, nil
+}
+
+// Selector applies include and exclude rules to records.
+type Selector struct {
+	include []compiled
+	exclude []compiled
+}
+
+type compiled struct {
+	rule    Rule
+	domain  matcher
+	name    matcher
+	content contentMatcher
+}
+
+type matcher func(string) bool
+
+type contentMatcher func(content string, last net.IP) bool
+
+// New compiles the rules. A record is selected when it matches at least one
+// include rule (or there are none) and no exclude rule.
+func New(include, exclude []Rule) (*Selector, error) {
+	s := &Selector{}
+	for _, r := range include {
+		c, err := compile(r)
+		if err != nil {
+			return nil, fmt.Errorf("include rule %q: %w", r, err)
+		}
+		s.include = append(s.include, c)
+	}
+	for _, r := range exclude {
+		c, err := compile(r)
+		if err != nil {
+			return nil, fmt.Errorf("exclude rule %q: %w", r, err)
+		}
+		s.exclude = append(s.exclude, c)
+	}
+	return s, nil
+}
+
+// Select reports whether record may be changed. last is the IP previously
+// applied for the record's family, if any. When the record is skipped, the
+// reason explains which rule decided it.
+func (s *Selector) Select(record provider.Record, last net.IP) (bool, string) {
+	if s == nil {
+		return true, ""
+	}
+	if len(s.include) > 0 {
+		included := false
+		for _, c := range s.include {
+			if c.matches(record, last) {
+				included = true
+				break
+			}
+		}
+		if !included {
+			return false, "not matched by any include rule"
+		}
+	}
+	for _, c := range s.exclude {
+		if c.matches(record, last) {
+			return false, fmt.Sprintf("excluded by rule %q", c.rule)
+		}
+	}
+	return true, ""
+}
+
+func (c compiled) matches(record provider.Record, last net.IP) bool {
+	if c.domain != nil && !c.domain(strings.ToLower(record.Domain)) {
+		return false
+	}
+	if c.name != nil && !c.name(strings.ToLower(record.Name)) {
+		return false
+	}
+	if c.rule.Type != "" && !strings.EqualFold(c.rule.Type, record.Type) {
+		return false
+	}
+	if c.content != nil && !c.content(record.Content, last) {
+		return false
+	}
+	return true
+}
+
+func compile(r Rule) (compiled, error) {
+	c := compiled{rule: r}
+	var err error
+	if r.Domain != "" {
+		if c.domain, err = compilePattern(r.Domain, true); err != nil {
+			return c, err
+		}
+	}
+	if r.Name != "" {
+		if c.name, err = compilePattern(r.Name, true); err != nil {
+			return c, err
+		}
+	}
+	if r.Content != "" {
+		if c.content, err = compileContent(r.Content); err != nil {
+			return c, err
+		}
+	}
+	return c, nil
+}
+
+// compilePattern compiles a glob or /regex/. With fold set the pattern
+// matches lower-cased input: globs are lower-cased too, while a regex is
+// left as written, since case carries meaning in escapes such as \D or \S,
+// and made case-insensitive instead.
+func compilePattern(p string, fold bool) (matcher, error) {
+	if len(p) > 1 && strings.HasPrefix(p, "/") && strings.HasSuffix(p, "/") {
+		expr := p[1 : len(p)-1]
+		if fold {
+			expr = "(?i)" + expr
+		}
+		re, err := regexp.Compile(expr)
+		if err != nil {
+			return nil, err
+		}
+		return re.MatchString, nil
+	}
+	if fold {
+		p = strings.ToLower(p)
+	}
+	if _, err := path.Match(p, ""); err != nil {
+		return nil, fmt.Errorf("invalid glob %q: %w", p, err)
+	}
+	return func(s string) bool {
+		ok, _ := path.Match(p, s)
+		return ok
+	}, nil
+}
+
+func compileContent(p string) (contentMatcher, error) {
+	if p == LastIP {
+		return func(content string, last net.IP) bool {
+			ip := net.ParseIP(content)
+			return last != nil && ip != nil && ip.Equal(last)
+		}, nil
+	}
+	if ip := net.ParseIP(p); ip != nil {
+		return func(content string, _ net.IP) bool {
+			other := net.ParseIP(content)
+			return other != nil && other.Equal(ip)
+		}, nil
+	}
+	if _, prefix, err := net.ParseCIDR(p); err == nil {
+		return func(content string, _ net.IP) bool {
+			ip := net.ParseIP(content)
+			return ip != nil && prefix.Contains(ip)
+		}, nil
+	}
+	m, err := compilePattern(p, false)
+	if err != nil {
+		return nil, err
+	}
+	return func(content string, _ net.IP) bool { return m(content) }, nil
+}
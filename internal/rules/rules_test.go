@@ -0,0 +1,102 @@
+package rules
+
+import (
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/erkki/dnsupdater/internal/provider"
+)
+
+func TestParse(t *testing.T) {
+	got, err := Parse("domain=example.com name=home*; content=203.0.113.0/24 ;")
+	if err != nil {
+		t.Fatalf("parse failed: %v", err)
+	}
+	if len(got) != 2 || got[0].Domain != "example.com" || got[0].Name != "home*" || got[1].Content != "203.0.113.0/24" {
+		t.Fatalf("unexpected rules: %+v", got)
+	}
+	if _, err := Parse("domain"); err == nil {
+		t.Fatalf("expected missing value to fail")
+	}
+	if _, err := Parse("zone=example.com"); err == nil {
+		t.Fatalf("expected unknown field to fail")
+	}
+}
+
+func TestSelect(t *testing.T) {
+	include, _ := Parse("domain=example.com; domain=/^lab-[0-9]+\\.net$/")
+	exclude, _ := Parse("name=vps*; content=198.51.100.0/24; domain=example.com name=@ type=AAAA")
+	s, err := New(include, exclude)
+	if err != nil {
+		t.Fatalf("compile failed: %v", err)
+	}
+
+	cases := []struct {
+		record provider.Record
+		want   bool
+		reason string
+	}{
+		{provider.Record{Domain: "example.com", Name: "home", Type: "A", Content: "192.0.2.1"}, true, ""},
+		{provider.Record{Domain: "lab-7.net", Name: "@", Type: "A", Content: "192.0.2.1"}, true, ""},
+		{provider.Record{Domain: "other.org", Name: "home", Type: "A", Content: "192.0.2.1"}, false, "not matched"},
+		{provider.Record{Domain: "example.com", Name: "vps-1", Type: "A", Content: "192.0.2.1"}, false, "name=vps*"},
+		{provider.Record{Domain: "example.com", Name: "home", Type: "A", Content: "198.51.100.4"}, false, "198.51.100.0/24"},
+		{provider.Record{Domain: "Example.COM", Name: "@", Type: "AAAA", Content: "2001:db8::1"}, false, "type=AAAA"},
+		{provider.Record{Domain: "example.com", Name: "@", Type: "A", Content: "192.0.2.1"}, true, ""},
+	}
+	for _, c := range cases {
+		got, reason := s.Select(c.record, nil)
+		if got != c.want || !strings.Contains(reason, c.reason) {
+			t.Errorf("Select(%+v) = %v %q, want %v containing %q", c.record, got, reason, c.want, c.reason)
+		}
+	}
+}
+
+func TestSelectRegexKeepsCase(t *testing.T) {
+	s, err := New([]Rule{{Domain: `/^EXAMPLE\.com$/`, Name: `/^web\D+$/`}}, []Rule{{Content: `/^\S+\s/`}})
+	if err != nil {
+		t.Fatalf("compile failed: %v", err)
+	}
+	cases := []struct {
+		record provider.Record
+		want   bool
+	}{
+		{provider.Record{Domain: "example.com", Name: "web-a", Type: "TXT", Content: "one"}, true},
+		{provider.Record{Domain: "Example.COM", Name: "WEB-A", Type: "TXT", Content: "one"}, true},
+		{provider.Record{Domain: "example.com", Name: "web1", Type: "TXT", Content: "one"}, false},
+		{provider.Record{Domain: "example.com", Name: "web-a", Type: "TXT", Content: "one two"}, false},
+	}
+	for _, c := range cases {
+		if got, _ := s.Select(c.record, nil); got != c.want {
+			t.Errorf("Select(%+v) = %v, want %v", c.record, got, c.want)
+		}
+	}
+}
+
+func TestSelectLastIP(t *testing.T) {
+	include, _ := Parse("content=@last")
+	s, err := New(include, nil)
+	if err != nil {
+		t.Fatalf("compile failed: %v", err)
+	}
+	record := provider.Record{Domain: "example.com", Name: "home", Type: "A", Content: "192.0.2.1"}
+	if ok, _ := s.Select(record, net.ParseIP("192.0.2.1")); !ok {
+		t.Fatalf("expected record at the last IP to be selected")
+	}
+	if ok, _ := s.Select(record, net.ParseIP("192.0.2.9")); ok {
+		t.Fatalf("expected record at another IP to be skipped")
+	}
+	if ok, _ := s.Select(record, nil); ok {
+		t.Fatalf("expected record to be skipped when no IP was applied yet")
+	}
+}
+
+func TestNewRejectsInvalidPatterns(t *testing.T) {
+	if _, err := New([]Rule{{Name: "/[/"}}, nil); err == nil {
+		t.Fatalf("expected invalid regex to fail")
+	}
+	if _, err := New(nil, []Rule{{Domain: "[a-"}}); err == nil {
+		t.Fatalf("expected invalid glob to fail")
+	}
+}
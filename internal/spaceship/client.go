@@ -10,9 +10,10 @@ import (
 	"net/http"
 	"net/url"
 	"path"
-	"strconv"
 	"strings"
 	"time"
+
+	"github.com/erkki/dnsupdater/internal/provider"
 )
 
 const (
@@ -22,75 +23,79 @@ const (
 	maxTTLSeconds  = 3600
 )
 
+// ErrConflict is matched by the APIError returned when Spaceship rejects a
+// write because it conflicts with existing records.
+var ErrConflict = provider.ErrConflict
+
 // Client interacts with the Spaceship API.
 type Client struct {
 	baseURL   string
 	apiKey    string
 	apiSecret string
 	http      *http.Client
+	retry     RetryPolicy
+	limits    limiters
+	fetch     FetchOptions
 }
 
-type Domain struct {
-	Name string `json:"name"`
-}
+// DNSRecord is the flattened record view shared with other providers.
+type DNSRecord = provider.Record
 
-type DNSRecord struct {
-	Domain  string `json:"domain"`
-	Name    string `json:"name"`
-	Type    string `json:"type"`
-	Content string `json:"content"`
-	TTL     int    `json:"ttl"`
-}
+var _ provider.DNSProvider = (*Client)(nil)
 
 func NewClient(baseURL, apiKey, apiSecret string, httpClient *http.Client) *Client {
 	if httpClient == nil {
 		httpClient = &http.Client{Timeout: 15 * time.Second}
 	}
-	return &Client{baseURL: baseURL, apiKey: apiKey, apiSecret: apiSecret, http: httpClient}
+	return &Client{baseURL: baseURL, apiKey: apiKey, apiSecret: apiSecret, http: httpClient, retry: DefaultRetryPolicy(), limits: newLimiters(DefaultRateLimits()), fetch: DefaultFetchOptions()}
 }
 
-func (c *Client) FetchRecords(ctx context.Context) ([]DNSRecord, error) {
-	domains, err := c.listDomains(ctx)
-	if err != nil {
-		return nil, err
+// Name implements provider.DNSProvider.
+func (c *Client) Name() string {
+	return "spaceship"
+}
+
+// Capabilities implements provider.DNSProvider. Spaceship overwrites records
+// in place when writing with force, and deletes records by their value.
+func (c *Client) Capabilities() provider.Capabilities {
+	return provider.Capabilities{
+		InPlaceUpdate: true,
+		DeleteByValue: true,
+		RecordTypes:   RecordTypes,
+		MinTTL:        minTTLSeconds,
+		MaxTTL:        maxTTLSeconds,
 	}
-	var records []DNSRecord
-	for _, d := range domains {
-		r, err := c.listRecords(ctx, d.Name)
+}
+
+// DeleteRecords implements provider.DNSProvider. Each record is matched by
+// type, name and value, so one record of a round-robin set can be removed
+// without touching the others.
+func (c *Client) DeleteRecords(ctx context.Context, domain string, records []DNSRecord) error {
+	items := make([]Record, 0, len(records))
+	for _, record := range records {
+		item, err := ParseRecord(record)
 		if err != nil {
-			return nil, err
-		}
-		for i := range r {
-			r[i].Domain = d.Name
+			return err
 		}
-		records = append(records, r...)
+		item.TTL = 0
+		items = append(items, item)
 	}
-	return records, nil
+	return c.RemoveRecords(ctx, domain, items...)
 }
 
-// DeleteRecords deletes DNS records for a domain.
-// The records are matched using type and name only.
-func (c *Client) DeleteRecords(ctx context.Context, domain string, records []DNSRecord) error {
+// RemoveRecords deletes records from a domain. Spaceship matches every field
+// but the TTL, which is ignored.
+func (c *Client) RemoveRecords(ctx context.Context, domain string, records ...Record) error {
 	if len(records) == 0 {
 		return nil
 	}
-
-	// Build delete payload with only type and name
-	deleteItems := make([]struct {
-		Type string `json:"type"`
-		Name string `json:"name"`
-	}, 0, len(records))
-	for _, record := range records {
-		deleteItems = append(deleteItems, struct {
-			Type string `json:"type"`
-			Name string `json:"name"`
-		}{
-			Type: record.Type,
-			Name: record.Name,
-		})
+	items := make([]Record, len(records))
+	for i, record := range records {
+		items[i] = record
+		items[i].TTL = 0
 	}
 
-	body, err := json.Marshal(deleteItems)
+	body, err := json.Marshal(items)
 	if err != nil {
 		return err
 	}
@@ -100,56 +105,54 @@ func (c *Client) DeleteRecords(ctx context.Context, domain string, records []DNS
 	if err != nil {
 		return err
 	}
+	return c.do(req, nil)
+}
 
-	resp, err := c.http.Do(req)
-	if err != nil {
-		return err
+// UpdateRecords updates multiple DNS records for a domain in a single request.
+// All records (A or AAAA) are updated to the new IP address, preserving their original TTL values.
+func (c *Client) UpdateRecords(ctx context.Context, domain string, records []DNSRecord, newIP net.IP) error {
+	updated := make([]DNSRecord, len(records))
+	for i, record := range records {
+		updated[i] = record
+		updated[i].Content = newIP.String()
 	}
-	defer resp.Body.Close()
-	if resp.StatusCode >= 300 {
-		data, _ := io.ReadAll(resp.Body)
-		return fmt.Errorf("spaceship delete failed: %s", string(data))
+	return c.UpsertRecords(ctx, domain, updated)
+}
+
+// UpsertRecords implements provider.DNSProvider. Each record is written with
+// its own Content as the value, and existing records with the same type and
+// name are overwritten.
+func (c *Client) UpsertRecords(ctx context.Context, domain string, records []DNSRecord) error {
+	items := make([]Record, 0, len(records))
+	for _, record := range records {
+		item, err := ParseRecord(record)
+		if err != nil {
+			return err
+		}
+		items = append(items, item)
 	}
-	return nil
+	return c.PutRecords(ctx, domain, true, items...)
 }
 
-// UpdateRecords updates multiple DNS records for a domain in a single request.
-// All A records are updated to the new IP address, preserving their original TTL values.
-func (c *Client) UpdateRecords(ctx context.Context, domain string, records []DNSRecord, newIP net.IP) error {
+// PutRecords creates records in a domain in a single request. With force,
+// existing records of the same type and name are overwritten; otherwise
+// Spaceship rejects the write with an error matching ErrConflict. TTLs are
+// clamped to the range Spaceship accepts.
+func (c *Client) PutRecords(ctx context.Context, domain string, force bool, records ...Record) error {
 	if len(records) == 0 {
 		return nil
 	}
 
 	payload := struct {
-		Force bool `json:"force"`
-		Items []struct {
-			Type    string `json:"type"`
-			Name    string `json:"name"`
-			TTL     int    `json:"ttl"`
-			Address string `json:"address"`
-		} `json:"items"`
+		Force bool     `json:"force"`
+		Items []Record `json:"items"`
 	}{
-		Force: true,
-		Items: make([]struct {
-			Type    string `json:"type"`
-			Name    string `json:"name"`
-			TTL     int    `json:"ttl"`
-			Address string `json:"address"`
-		}, 0, len(records)),
+		Force: force,
+		Items: make([]Record, len(records)),
 	}
-
-	for _, record := range records {
-		payload.Items = append(payload.Items, struct {
-			Type    string `json:"type"`
-			Name    string `json:"name"`
-			TTL     int    `json:"ttl"`
-			Address string `json:"address"`
-		}{
-			Type:    record.Type,
-			Name:    record.Name,
-			TTL:     sanitizeTTL(record.TTL),
-			Address: newIP.String(),
-		})
+	for i, record := range records {
+		payload.Items[i] = record
+		payload.Items[i].TTL = sanitizeTTL(record.TTL)
 	}
 
 	body, err := json.Marshal(payload)
@@ -162,105 +165,34 @@ func (c *Client) UpdateRecords(ctx context.Context, domain string, records []DNS
 	if err != nil {
 		return err
 	}
+	return c.do(req, nil)
+}
 
-	resp, err := c.http.Do(req)
+// RecordSet returns the records of a domain with the given type and name,
+// which is empty when the set does not exist. SRV records are named by their
+// host, as in Record.
+func (c *Client) RecordSet(ctx context.Context, domain, recordType, name string) ([]Record, error) {
+	records, err := c.ListRecords(ctx, domain)
 	if err != nil {
-		return err
-	}
-	defer resp.Body.Close()
-	if resp.StatusCode >= 300 {
-		data, _ := io.ReadAll(resp.Body)
-		return fmt.Errorf("spaceship update failed: %s", string(data))
+		return nil, err
 	}
-	return nil
-}
-
-func (c *Client) listDomains(ctx context.Context) ([]Domain, error) {
-	var (
-		skip    int
-		results []Domain
-	)
-
-	for {
-		params := url.Values{}
-		params.Set("take", strconv.Itoa(domainPageSize))
-		params.Set("skip", strconv.Itoa(skip))
-
-		req, err := c.newRequest(ctx, http.MethodGet, path.Join("v1", "domains"), nil, params)
-		if err != nil {
-			return nil, err
-		}
-
-		var payload struct {
-			Items []Domain `json:"items"`
-			Total int      `json:"total"`
-		}
-
-		if err := c.do(req, &payload); err != nil {
-			return nil, err
-		}
-
-		if len(payload.Items) == 0 {
-			break
-		}
-
-		results = append(results, payload.Items...)
-		skip += len(payload.Items)
-
-		if skip >= payload.Total {
-			break
+	var set []Record
+	for _, record := range records {
+		if strings.EqualFold(record.Type, recordType) && strings.EqualFold(record.Name, name) {
+			set = append(set, record)
 		}
 	}
-
-	return results, nil
+	return set, nil
 }
 
-func (c *Client) listRecords(ctx context.Context, domain string) ([]DNSRecord, error) {
-	var (
-		skip    int
-		results []DNSRecord
-	)
-
-	for {
-		params := url.Values{}
-		params.Set("take", strconv.Itoa(recordPageSize))
-		params.Set("skip", strconv.Itoa(skip))
-
-		endpoint := path.Join("v1", "dns", "records", domain)
-		req, err := c.newRequest(ctx, http.MethodGet, endpoint, nil, params)
-		if err != nil {
-			return nil, err
-		}
-
-		var payload struct {
-			Items []dnsRecordItem `json:"items"`
-			Total int             `json:"total"`
-		}
-
-		if err := c.do(req, &payload); err != nil {
-			return nil, err
-		}
-
-		if len(payload.Items) == 0 {
-			break
-		}
-
-		for _, item := range payload.Items {
-			results = append(results, DNSRecord{
-				Name:    item.Name,
-				Type:    item.Type,
-				TTL:     item.TTL,
-				Content: item.content(),
-			})
-		}
-
-		skip += len(payload.Items)
-		if skip >= payload.Total {
-			break
-		}
+// ListRecords returns every record of a domain.
+func (c *Client) ListRecords(ctx context.Context, domain string) ([]Record, error) {
+	var results []Record
+	pager := c.Records(ctx, domain)
+	for pager.Next() {
+		results = append(results, pager.Item())
 	}
-
-	return results, nil
+	return results, pager.Err()
 }
 
 func (c *Client) newRequest(ctx context.Context, method, endpoint string, body io.Reader, query url.Values) (*http.Request, error) {
@@ -285,14 +217,13 @@ func (c *Client) newRequest(ctx context.Context, method, endpoint string, body i
 }
 
 func (c *Client) do(req *http.Request, v interface{}) error {
-	resp, err := c.http.Do(req)
+	resp, err := c.send(req)
 	if err != nil {
 		return err
 	}
 	defer resp.Body.Close()
 	if resp.StatusCode >= 300 {
-		data, _ := io.ReadAll(resp.Body)
-		return fmt.Errorf("spaceship API error: %s", string(data))
+		return newAPIError(req, resp)
 	}
 	if v == nil {
 		return nil
@@ -300,35 +231,6 @@ func (c *Client) do(req *http.Request, v interface{}) error {
 	return json.NewDecoder(resp.Body).Decode(v)
 }
 
-type dnsRecordItem struct {
-	Name     string `json:"name"`
-	Type     string `json:"type"`
-	TTL      int    `json:"ttl"`
-	Address  string `json:"address,omitempty"`
-	Content  string `json:"content,omitempty"`
-	Target   string `json:"target,omitempty"`
-	Alias    string `json:"aliasName,omitempty"`
-	Value    string `json:"value,omitempty"`
-	Priority string `json:"priority,omitempty"`
-}
-
-func (r dnsRecordItem) content() string {
-	switch {
-	case r.Address != "":
-		return r.Address
-	case r.Content != "":
-		return r.Content
-	case r.Target != "":
-		return r.Target
-	case r.Alias != "":
-		return r.Alias
-	case r.Value != "":
-		return r.Value
-	default:
-		return ""
-	}
-}
-
 func sanitizeTTL(ttl int) int {
 	if ttl < minTTLSeconds {
 		return minTTLSeconds
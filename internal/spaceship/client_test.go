@@ -3,10 +3,18 @@ package spaceship
 import (
 	"context"
 	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
 	"net"
 	"net/http"
 	"net/http/httptest"
+	"strings"
+	"sync"
 	"testing"
+	"time"
+
+	"github.com/erkki/dnsupdater/internal/provider"
 )
 
 func TestFetchRecords(t *testing.T) {
@@ -46,6 +54,136 @@ func TestFetchRecords(t *testing.T) {
 	}
 }
 
+// domainsServer serves the domains d0..d(n-1), each holding one A record.
+// handle runs before the records of a domain are served; it returns false to
+// stop the request.
+func domainsServer(t *testing.T, n int, handle func(w http.ResponseWriter, r *http.Request, domain string) bool) *Client {
+	t.Helper()
+	mux := http.NewServeMux()
+	mux.HandleFunc("/v1/domains", func(w http.ResponseWriter, r *http.Request) {
+		items := make([]string, n)
+		for i := range items {
+			items[i] = fmt.Sprintf(`{"name":"d%d.com"}`, i)
+		}
+		fmt.Fprintf(w, `{"items":[%s],"total":%d}`, strings.Join(items, ","), n)
+	})
+	mux.HandleFunc("/v1/dns/records/{domain}", func(w http.ResponseWriter, r *http.Request) {
+		domain := r.PathValue("domain")
+		if !handle(w, r, domain) {
+			return
+		}
+		fmt.Fprintf(w, `{"items":[{"name":"@","type":"A","ttl":60,"address":"1.1.1.1"}],"total":1}`)
+	})
+	srv := httptest.NewServer(mux)
+	t.Cleanup(srv.Close)
+	client := NewClient(srv.URL, "key", "secret", srv.Client())
+	client.SetRateLimits(RateLimits{})
+	client.SetRetryPolicy(fastRetries())
+	return client
+}
+
+func TestFetchRecordsConcurrently(t *testing.T) {
+	var mu sync.Mutex
+	inFlight, peak := 0, 0
+	client := domainsServer(t, 8, func(w http.ResponseWriter, r *http.Request, domain string) bool {
+		mu.Lock()
+		inFlight++
+		peak = max(peak, inFlight)
+		mu.Unlock()
+		// Earlier domains answer last, so completion order differs from
+		// listing order.
+		var i int
+		fmt.Sscanf(domain, "d%d.com", &i)
+		time.Sleep(time.Duration(8-i) * 5 * time.Millisecond)
+		mu.Lock()
+		inFlight--
+		mu.Unlock()
+		return true
+	})
+	client.SetFetchOptions(FetchOptions{Workers: 3})
+
+	recs, err := client.FetchRecords(context.Background())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(recs) != 8 {
+		t.Fatalf("expected 8 records, got %d", len(recs))
+	}
+	for i, rec := range recs {
+		if want := fmt.Sprintf("d%d.com", i); rec.Domain != want {
+			t.Fatalf("record %d belongs to %s, want %s", i, rec.Domain, want)
+		}
+	}
+	if peak < 2 || peak > 3 {
+		t.Fatalf("expected 2 to 3 concurrent requests, got %d", peak)
+	}
+}
+
+func TestFetchRecordsCancelsOnFirstError(t *testing.T) {
+	var mu sync.Mutex
+	var requested []string
+	client := domainsServer(t, 10, func(w http.ResponseWriter, r *http.Request, domain string) bool {
+		mu.Lock()
+		requested = append(requested, domain)
+		mu.Unlock()
+		if domain == "d1.com" {
+			http.Error(w, `{"detail":"boom"}`, http.StatusBadRequest)
+			return false
+		}
+		// Hold the other reads until the client gives up on them.
+		select {
+		case <-r.Context().Done():
+		case <-time.After(5 * time.Second):
+		}
+		return false
+	})
+	client.SetFetchOptions(FetchOptions{Workers: 2})
+
+	start := time.Now()
+	recs, err := client.FetchRecords(context.Background())
+	if err == nil || !strings.Contains(err.Error(), "domain d1.com") {
+		t.Fatalf("expected error for d1.com, got %v (records %+v)", err, recs)
+	}
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Fatalf("pending reads were not cancelled, took %s", elapsed)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if len(requested) > 3 {
+		t.Fatalf("expected remaining domains to be skipped, requested %v", requested)
+	}
+}
+
+func TestFetchRecordsPartial(t *testing.T) {
+	client := domainsServer(t, 4, func(w http.ResponseWriter, r *http.Request, domain string) bool {
+		if domain == "d2.com" {
+			http.Error(w, `{"detail":"gone"}`, http.StatusNotFound)
+			return false
+		}
+		return true
+	})
+	client.SetFetchOptions(FetchOptions{Workers: 2, Partial: true})
+
+	recs, err := client.FetchRecords(context.Background())
+	var partial *provider.PartialError
+	if !errors.As(err, &partial) {
+		t.Fatalf("expected a partial error, got %v", err)
+	}
+	if got := partial.Domains(); len(got) != 1 || got[0] != "d2.com" {
+		t.Fatalf("unexpected failed domains: %v", got)
+	}
+	if !errors.Is(err, provider.ErrNotFound) {
+		t.Fatalf("expected the domain error to be wrapped, got %v", err)
+	}
+	var domains []string
+	for _, rec := range recs {
+		domains = append(domains, rec.Domain)
+	}
+	if strings.Join(domains, ",") != "d0.com,d1.com,d3.com" {
+		t.Fatalf("unexpected records: %v", domains)
+	}
+}
+
 func TestDeleteRecords(t *testing.T) {
 	mux := http.NewServeMux()
 	mux.HandleFunc("/v1/dns/records/example.com", func(w http.ResponseWriter, r *http.Request) {
@@ -88,6 +226,92 @@ func TestDeleteRecords(t *testing.T) {
 	}
 }
 
+func TestDeleteRecordsMatchesValue(t *testing.T) {
+	var got []map[string]any
+	mux := http.NewServeMux()
+	mux.HandleFunc("DELETE /v1/dns/records/example.com", func(w http.ResponseWriter, r *http.Request) {
+		if err := json.NewDecoder(r.Body).Decode(&got); err != nil {
+			t.Fatalf("failed to decode body: %v", err)
+		}
+		w.WriteHeader(http.StatusNoContent)
+	})
+	srv := httptest.NewServer(mux)
+	t.Cleanup(srv.Close)
+
+	client := NewClient(srv.URL, "key", "secret", srv.Client())
+	// One address of a round-robin set.
+	err := client.DeleteRecords(context.Background(), "example.com", []DNSRecord{
+		{Domain: "example.com", Name: "www", Type: "A", Content: "192.0.2.2", TTL: 300},
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	want := map[string]any{"type": "A", "name": "www", "address": "192.0.2.2"}
+	if len(got) != 1 || len(got[0]) != len(want) {
+		t.Fatalf("unexpected delete body: %v", got)
+	}
+	for k, v := range want {
+		if got[0][k] != v {
+			t.Fatalf("unexpected delete body: %v", got)
+		}
+	}
+}
+
+func TestPutRecordsWithoutForce(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("PUT /v1/dns/records/example.com", func(w http.ResponseWriter, r *http.Request) {
+		var payload struct {
+			Force bool     `json:"force"`
+			Items []Record `json:"items"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+			t.Fatalf("failed to decode body: %v", err)
+		}
+		if payload.Force || len(payload.Items) != 1 || payload.Items[0].Port != 5060 || payload.Items[0].TTL != 60 {
+			t.Fatalf("unexpected payload: %+v", payload)
+		}
+		w.WriteHeader(http.StatusConflict)
+		w.Write([]byte(`{"detail":"record exists"}`))
+	})
+	srv := httptest.NewServer(mux)
+	t.Cleanup(srv.Close)
+
+	client := NewClient(srv.URL, "key", "secret", srv.Client())
+	err := client.PutRecords(context.Background(), "example.com", false, Record{
+		Type: "SRV", Name: "@", TTL: 5, Service: "_sip", Protocol: "_udp", Priority: 10, Weight: 5, Port: 5060, Target: "sip.example.com",
+	})
+	if !errors.Is(err, ErrConflict) {
+		t.Fatalf("expected a conflict, got %v", err)
+	}
+}
+
+func TestRecordSet(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/v1/dns/records/example.com", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"items":[
+			{"type":"A","name":"www","ttl":300,"address":"192.0.2.1"},
+			{"type":"A","name":"www","ttl":300,"address":"192.0.2.2"},
+			{"type":"AAAA","name":"www","ttl":300,"address":"2001:db8::1"},
+			{"type":"A","name":"@","ttl":300,"address":"192.0.2.3"}
+		],"total":4}`))
+	})
+	srv := httptest.NewServer(mux)
+	t.Cleanup(srv.Close)
+
+	client := NewClient(srv.URL, "key", "secret", srv.Client())
+	set, err := client.RecordSet(context.Background(), "example.com", "a", "WWW")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(set) != 2 || set[0].Address != "192.0.2.1" || set[1].Address != "192.0.2.2" {
+		t.Fatalf("unexpected set: %+v", set)
+	}
+	set, err = client.RecordSet(context.Background(), "example.com", "CNAME", "www")
+	if err != nil || len(set) != 0 {
+		t.Fatalf("expected an empty set, got %+v, %v", set, err)
+	}
+}
+
 func TestUpdateRecords(t *testing.T) {
 	mux := http.NewServeMux()
 	mux.HandleFunc("/v1/dns/records/example.com", func(w http.ResponseWriter, r *http.Request) {
@@ -143,3 +367,244 @@ func TestUpdateRecords(t *testing.T) {
 		t.Fatalf("update failed: %v", err)
 	}
 }
+
+func TestUpsertRecordsUsesTypeSpecificFields(t *testing.T) {
+	var got []map[string]any
+	mux := http.NewServeMux()
+	mux.HandleFunc("/v1/dns/records/example.com", func(w http.ResponseWriter, r *http.Request) {
+		var payload struct {
+			Items []map[string]any `json:"items"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+			t.Fatalf("failed to decode body: %v", err)
+		}
+		got = payload.Items
+		w.WriteHeader(http.StatusNoContent)
+	})
+	srv := httptest.NewServer(mux)
+	t.Cleanup(srv.Close)
+
+	client := NewClient(srv.URL, "key", "secret", srv.Client())
+	err := client.UpsertRecords(context.Background(), "example.com", []DNSRecord{
+		{Name: "www", Type: "CNAME", Content: "example.com", TTL: 300},
+		{Name: "@", Type: "MX", Content: "10 mail.example.com", TTL: 300},
+		{Name: "@", Type: "TXT", Content: "v=spf1 -all", TTL: 300},
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(got) != 3 || got[0]["cname"] != "example.com" || got[1]["exchange"] != "mail.example.com" ||
+		got[1]["preference"] != float64(10) || got[2]["value"] != "v=spf1 -all" {
+		t.Fatalf("unexpected items: %v", got)
+	}
+	if _, ok := got[0]["address"]; ok {
+		t.Fatalf("CNAME must not carry an address: %v", got[0])
+	}
+
+	if err := client.UpsertRecords(context.Background(), "example.com", []DNSRecord{{Name: "@", Type: "MX", Content: "mail"}}); err == nil {
+		t.Fatalf("expected malformed MX content to fail")
+	}
+}
+
+func fastRetries() RetryPolicy {
+	return RetryPolicy{MaxAttempts: 3, BaseDelay: time.Millisecond, MaxDelay: 5 * time.Millisecond, MaxRetryAfter: time.Second}
+}
+
+func TestRetriesTransientFailures(t *testing.T) {
+	var calls int
+	mux := http.NewServeMux()
+	mux.HandleFunc("/v1/dns/records/example.com", func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		body, _ := io.ReadAll(r.Body)
+		if len(body) == 0 {
+			t.Fatalf("attempt %d sent an empty body", calls)
+		}
+		switch calls {
+		case 1:
+			w.Header().Set("Retry-After", "0")
+			w.WriteHeader(http.StatusServiceUnavailable)
+		case 2:
+			w.WriteHeader(http.StatusBadGateway)
+		default:
+			w.WriteHeader(http.StatusNoContent)
+		}
+	})
+	srv := httptest.NewServer(mux)
+	t.Cleanup(srv.Close)
+
+	client := NewClient(srv.URL, "key", "secret", srv.Client())
+	client.SetRetryPolicy(fastRetries())
+	err := client.UpsertRecords(context.Background(), "example.com", []DNSRecord{{Name: "@", Type: "A", Content: "198.51.100.2"}})
+	if err != nil {
+		t.Fatalf("expected the write to succeed after retries: %v", err)
+	}
+	if calls != 3 {
+		t.Fatalf("expected 3 attempts, got %d", calls)
+	}
+}
+
+func TestRetryGivesUp(t *testing.T) {
+	cases := map[string]func(w http.ResponseWriter){
+		"attempts exhausted": func(w http.ResponseWriter) { w.WriteHeader(http.StatusServiceUnavailable) },
+		"retry-after too long": func(w http.ResponseWriter) {
+			w.Header().Set("Retry-After", "3600")
+			w.WriteHeader(http.StatusTooManyRequests)
+		},
+		"not retryable": func(w http.ResponseWriter) { w.WriteHeader(http.StatusBadRequest) },
+	}
+	wantCalls := map[string]int{"attempts exhausted": 3, "retry-after too long": 1, "not retryable": 1}
+	for name, respond := range cases {
+		var calls int
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			calls++
+			respond(w)
+		}))
+		client := NewClient(srv.URL, "key", "secret", srv.Client())
+		client.SetRetryPolicy(fastRetries())
+		if _, err := client.FetchRecords(context.Background()); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+		if calls != wantCalls[name] {
+			t.Errorf("%s: expected %d attempts, got %d", name, wantCalls[name], calls)
+		}
+		srv.Close()
+	}
+}
+
+func TestDoesNotRetryNonIdempotentRequests(t *testing.T) {
+	var calls int
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	t.Cleanup(srv.Close)
+
+	client := NewClient(srv.URL, "key", "secret", srv.Client())
+	client.SetRetryPolicy(fastRetries())
+	req, err := client.newRequest(context.Background(), http.MethodPost, "v1/anything", strings.NewReader("{}"), nil)
+	if err != nil {
+		t.Fatalf("new request: %v", err)
+	}
+	resp, err := client.send(req)
+	if err != nil {
+		t.Fatalf("send: %v", err)
+	}
+	resp.Body.Close()
+	if calls != 1 {
+		t.Fatalf("expected POST to be sent once, got %d", calls)
+	}
+}
+
+func TestBackoffGrowsWithJitter(t *testing.T) {
+	p := RetryPolicy{BaseDelay: 100 * time.Millisecond, MaxDelay: time.Second}
+	for attempt, limit := range map[int]time.Duration{1: 100 * time.Millisecond, 2: 200 * time.Millisecond, 3: 400 * time.Millisecond, 6: time.Second} {
+		for i := 0; i < 20; i++ {
+			d := p.backoff(attempt)
+			if d < limit/2 || d > limit {
+				t.Fatalf("backoff(%d) = %s, want within [%s, %s]", attempt, d, limit/2, limit)
+			}
+		}
+	}
+}
+
+func TestAPIError(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/v1/dns/records/example.com", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Request-Id", "req-42")
+		w.WriteHeader(http.StatusUnprocessableEntity)
+		w.Write([]byte(`{"detail":"validation failed","code":"INVALID","data":[{"field":"items[0].ttl","details":"must be at least 60"}]}`))
+	})
+	mux.HandleFunc("/v1/domains", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusForbidden)
+		w.Write([]byte("forbidden"))
+	})
+	srv := httptest.NewServer(mux)
+	t.Cleanup(srv.Close)
+	client := NewClient(srv.URL, "key", "secret", srv.Client())
+
+	err := client.UpsertRecords(context.Background(), "example.com", []DNSRecord{{Name: "@", Type: "A", Content: "198.51.100.2"}})
+	var apiErr *APIError
+	if !errors.As(err, &apiErr) {
+		t.Fatalf("expected an APIError, got %v", err)
+	}
+	if apiErr.StatusCode != http.StatusUnprocessableEntity || apiErr.Code != "INVALID" || apiErr.Message != "validation failed" ||
+		apiErr.Method != http.MethodPut || apiErr.Path != "/v1/dns/records/example.com" || apiErr.RequestID != "req-42" {
+		t.Fatalf("unexpected error fields: %+v", apiErr)
+	}
+	if len(apiErr.Fields) != 1 || apiErr.Fields[0].Field != "items[0].ttl" || apiErr.Fields[0].Message != "must be at least 60" {
+		t.Fatalf("unexpected field errors: %+v", apiErr.Fields)
+	}
+	if IsUnauthorized(err) || IsNotFound(err) || IsRateLimited(err) || errors.Is(err, ErrConflict) {
+		t.Fatalf("validation error matched another class: %v", err)
+	}
+	if !strings.Contains(err.Error(), "items[0].ttl: must be at least 60") {
+		t.Fatalf("error message lacks field details: %s", err)
+	}
+
+	_, err = client.FetchRecords(context.Background())
+	if !IsUnauthorized(err) {
+		t.Fatalf("expected 403 to be unauthorized, got %v", err)
+	}
+	if !errors.As(err, &apiErr) || apiErr.Body != "forbidden" {
+		t.Fatalf("expected the raw body to be kept, got %+v", apiErr)
+	}
+}
+
+func TestRateLimiterThrottlesPerClass(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"items":[],"total":0}`))
+	}))
+	t.Cleanup(srv.Close)
+
+	client := NewClient(srv.URL, "key", "secret", srv.Client())
+	client.SetRateLimits(RateLimits{List: Limit{Rate: 50, Burst: 2}})
+
+	start := time.Now()
+	var wg sync.WaitGroup
+	for i := 0; i < 6; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := client.FetchRecords(context.Background()); err != nil {
+				t.Errorf("fetch: %v", err)
+			}
+		}()
+	}
+	wg.Wait()
+	// Two requests fit the burst, the other four wait 20ms each in turn.
+	if elapsed := time.Since(start); elapsed < 60*time.Millisecond {
+		t.Fatalf("expected requests to be throttled, took %s", elapsed)
+	}
+	stats := client.RateLimitStats()
+	if stats.List.Requests != 6 || stats.List.Waits != 4 || stats.List.Waited <= 0 {
+		t.Fatalf("unexpected list stats: %+v", stats.List)
+	}
+	if stats.Write.Requests != 0 {
+		t.Fatalf("reads must not use the write limiter: %+v", stats.Write)
+	}
+}
+
+func TestRateLimiterPausesOnExhaustedQuota(t *testing.T) {
+	var calls int
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		if calls == 1 {
+			w.Header().Set("X-RateLimit-Remaining", "0")
+			w.Header().Set("X-RateLimit-Reset", "1")
+		}
+		w.Write([]byte(`{"items":[],"total":0}`))
+	}))
+	t.Cleanup(srv.Close)
+
+	client := NewClient(srv.URL, "key", "secret", srv.Client())
+	client.SetRateLimits(RateLimits{})
+	for i := 0; i < 2; i++ {
+		if _, err := client.FetchRecords(context.Background()); err != nil {
+			t.Fatalf("fetch: %v", err)
+		}
+	}
+	stats := client.RateLimitStats().List
+	if stats.Waits != 1 || stats.Waited < 900*time.Millisecond {
+		t.Fatalf("expected the second request to wait for the reset, got %+v", stats)
+	}
+}
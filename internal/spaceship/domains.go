@@ -0,0 +1,160 @@
+package spaceship
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"path"
+	"strings"
+	"time"
+)
+
+// Domain is a domain registered in the Spaceship account.
+type Domain struct {
+	Name             string            `json:"name"`
+	UnicodeName      string            `json:"unicodeName,omitempty"`
+	AutoRenew        bool              `json:"autoRenew"`
+	RegistrationDate time.Time         `json:"registrationDate"`
+	ExpirationDate   time.Time         `json:"expirationDate"`
+	LifecycleStatus  string            `json:"lifecycleStatus,omitempty"`
+	EPPStatuses      []string          `json:"eppStatuses,omitempty"`
+	Privacy          PrivacyProtection `json:"privacyProtection"`
+	Nameservers      Nameservers       `json:"nameservers"`
+}
+
+// PrivacyProtection is the WHOIS privacy setting of a domain. Level is
+// "high" when contact details are hidden and "public" otherwise.
+type PrivacyProtection struct {
+	Level       string `json:"level"`
+	ContactForm bool   `json:"contactForm"`
+}
+
+// Nameserver providers: Spaceship's own nameservers, or the hosts listed.
+const (
+	NameserversBasic  = "basic"
+	NameserversCustom = "custom"
+)
+
+// Nameservers is the delegation of a domain.
+type Nameservers struct {
+	Provider string   `json:"provider"`
+	Hosts    []string `json:"hosts,omitempty"`
+}
+
+// Locked reports whether the domain is locked against transfers.
+func (d Domain) Locked() bool {
+	for _, status := range d.EPPStatuses {
+		if strings.EqualFold(status, "clientTransferProhibited") {
+			return true
+		}
+	}
+	return false
+}
+
+// PrivacyEnabled reports whether the domain's contact details are hidden.
+func (d Domain) PrivacyEnabled() bool {
+	return strings.EqualFold(d.Privacy.Level, "high")
+}
+
+// DomainFilter selects domains in ListDomains. Zero fields match every
+// domain.
+type DomainFilter struct {
+	// Name is a glob matched against the domain name, e.g. "*.dev".
+	Name string
+	// ExpiresBefore keeps the domains that expire before this time.
+	ExpiresBefore time.Time
+	// AutoRenew keeps the domains whose auto-renewal is set as given.
+	AutoRenew *bool
+	// OrderBy sorts the list on the API side: "name", "registrationDate" or
+	// "expirationDate", prefixed with "-" for descending order.
+	OrderBy string
+}
+
+func (f DomainFilter) match(d Domain) (bool, error) {
+	if f.Name != "" {
+		ok, err := path.Match(strings.ToLower(f.Name), strings.ToLower(d.Name))
+		if err != nil {
+			return false, fmt.Errorf("invalid domain filter %q: %w", f.Name, err)
+		}
+		if !ok {
+			return false, nil
+		}
+	}
+	if !f.ExpiresBefore.IsZero() && !d.ExpirationDate.Before(f.ExpiresBefore) {
+		return false, nil
+	}
+	if f.AutoRenew != nil && d.AutoRenew != *f.AutoRenew {
+		return false, nil
+	}
+	return true, nil
+}
+
+// ListDomains returns the domains of the account that match filter.
+func (c *Client) ListDomains(ctx context.Context, filter DomainFilter) ([]Domain, error) {
+	if _, err := filter.match(Domain{}); err != nil {
+		return nil, err
+	}
+	var results []Domain
+	pager := c.Domains(ctx, filter.OrderBy)
+	for pager.Next() {
+		d := pager.Item()
+		if ok, _ := filter.match(d); ok {
+			results = append(results, d)
+		}
+	}
+	return results, pager.Err()
+}
+
+// GetDomain returns the details of one domain.
+func (c *Client) GetDomain(ctx context.Context, domain string) (Domain, error) {
+	req, err := c.newRequest(ctx, http.MethodGet, path.Join("v1", "domains", domain), nil, nil)
+	if err != nil {
+		return Domain{}, err
+	}
+	var d Domain
+	if err := c.do(req, &d); err != nil {
+		return Domain{}, err
+	}
+	return d, nil
+}
+
+// GetNameservers returns the nameservers a domain is delegated to.
+func (c *Client) GetNameservers(ctx context.Context, domain string) (Nameservers, error) {
+	d, err := c.GetDomain(ctx, domain)
+	if err != nil {
+		return Nameservers{}, err
+	}
+	return d.Nameservers, nil
+}
+
+// SetNameservers delegates a domain to ns and returns the delegation as
+// saved by Spaceship. Custom nameservers need at least two hosts.
+func (c *Client) SetNameservers(ctx context.Context, domain string, ns Nameservers) (Nameservers, error) {
+	switch ns.Provider {
+	case NameserversBasic:
+		ns.Hosts = nil
+	case NameserversCustom:
+		if len(ns.Hosts) < 2 {
+			return Nameservers{}, fmt.Errorf("spaceship: custom nameservers need at least 2 hosts, got %d", len(ns.Hosts))
+		}
+	default:
+		return Nameservers{}, fmt.Errorf("spaceship: unknown nameserver provider %q", ns.Provider)
+	}
+
+	body, err := json.Marshal(ns)
+	if err != nil {
+		return Nameservers{}, err
+	}
+	endpoint := path.Join("v1", "domains", domain, "nameservers")
+	req, err := c.newRequest(ctx, http.MethodPut, endpoint, bytes.NewReader(body), nil)
+	if err != nil {
+		return Nameservers{}, err
+	}
+	var saved Nameservers
+	if err := c.do(req, &saved); err != nil {
+		return Nameservers{}, err
+	}
+	return saved, nil
+}
@@ -0,0 +1,124 @@
+package spaceship
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+const domainsJSON = `{"items":[
+	{"name":"example.com","autoRenew":true,"expirationDate":"2026-03-01T00:00:00.000Z","eppStatuses":["clientTransferProhibited"],"privacyProtection":{"level":"high","contactForm":true},"nameservers":{"provider":"basic"}},
+	{"name":"example.dev","autoRenew":false,"expirationDate":"2025-11-15T12:00:00Z","privacyProtection":{"level":"public"},"nameservers":{"provider":"custom","hosts":["ns1.example.net","ns2.example.net"]}},
+	{"name":"other.dev","autoRenew":true,"expirationDate":"2027-01-01T00:00:00Z"}
+],"total":3}`
+
+func TestListDomainsFilters(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/v1/domains", func(w http.ResponseWriter, r *http.Request) {
+		if got := r.URL.Query().Get("orderBy"); got != "expirationDate" {
+			t.Fatalf("unexpected orderBy: %q", got)
+		}
+		w.Write([]byte(domainsJSON))
+	})
+	srv := httptest.NewServer(mux)
+	t.Cleanup(srv.Close)
+	client := NewClient(srv.URL, "key", "secret", srv.Client())
+
+	renew := true
+	tests := []struct {
+		filter DomainFilter
+		want   []string
+	}{
+		{DomainFilter{}, []string{"example.com", "example.dev", "other.dev"}},
+		{DomainFilter{Name: "*.dev"}, []string{"example.dev", "other.dev"}},
+		{DomainFilter{ExpiresBefore: time.Date(2026, 6, 1, 0, 0, 0, 0, time.UTC)}, []string{"example.com", "example.dev"}},
+		{DomainFilter{Name: "*.dev", AutoRenew: &renew}, []string{"other.dev"}},
+	}
+	for _, tt := range tests {
+		tt.filter.OrderBy = "expirationDate"
+		domains, err := client.ListDomains(context.Background(), tt.filter)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		var got []string
+		for _, d := range domains {
+			got = append(got, d.Name)
+		}
+		if len(got) != len(tt.want) {
+			t.Fatalf("filter %+v: got %v, want %v", tt.filter, got, tt.want)
+		}
+		for i := range got {
+			if got[i] != tt.want[i] {
+				t.Fatalf("filter %+v: got %v, want %v", tt.filter, got, tt.want)
+			}
+		}
+	}
+
+	if _, err := client.ListDomains(context.Background(), DomainFilter{Name: "["}); err == nil {
+		t.Fatalf("expected an invalid glob to be rejected")
+	}
+}
+
+func TestGetDomain(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /v1/domains/example.com", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"name":"example.com","autoRenew":true,"registrationDate":"2020-03-01T00:00:00Z","expirationDate":"2026-03-01T00:00:00Z",
+			"lifecycleStatus":"registered","eppStatuses":["clientTransferProhibited"],"privacyProtection":{"level":"high","contactForm":true},
+			"nameservers":{"provider":"custom","hosts":["ns1.example.net","ns2.example.net"]}}`))
+	})
+	srv := httptest.NewServer(mux)
+	t.Cleanup(srv.Close)
+	client := NewClient(srv.URL, "key", "secret", srv.Client())
+
+	d, err := client.GetDomain(context.Background(), "example.com")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !d.AutoRenew || !d.Locked() || !d.PrivacyEnabled() || d.LifecycleStatus != "registered" {
+		t.Fatalf("unexpected details: %+v", d)
+	}
+	if !d.ExpirationDate.Equal(time.Date(2026, 3, 1, 0, 0, 0, 0, time.UTC)) {
+		t.Fatalf("unexpected expiry: %s", d.ExpirationDate)
+	}
+	ns, err := client.GetNameservers(context.Background(), "example.com")
+	if err != nil || ns.Provider != NameserversCustom || len(ns.Hosts) != 2 {
+		t.Fatalf("unexpected nameservers: %+v, %v", ns, err)
+	}
+}
+
+func TestSetNameservers(t *testing.T) {
+	var got Nameservers
+	mux := http.NewServeMux()
+	mux.HandleFunc("PUT /v1/domains/example.com/nameservers", func(w http.ResponseWriter, r *http.Request) {
+		got = Nameservers{}
+		if err := json.NewDecoder(r.Body).Decode(&got); err != nil {
+			t.Fatalf("failed to decode body: %v", err)
+		}
+		json.NewEncoder(w).Encode(got)
+	})
+	srv := httptest.NewServer(mux)
+	t.Cleanup(srv.Close)
+	client := NewClient(srv.URL, "key", "secret", srv.Client())
+
+	want := Nameservers{Provider: NameserversCustom, Hosts: []string{"ns1.example.net", "ns2.example.net"}}
+	saved, err := client.SetNameservers(context.Background(), "example.com", want)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got.Provider != want.Provider || len(got.Hosts) != 2 || len(saved.Hosts) != 2 {
+		t.Fatalf("unexpected request %+v or response %+v", got, saved)
+	}
+
+	if _, err := client.SetNameservers(context.Background(), "example.com", Nameservers{Provider: NameserversCustom, Hosts: []string{"ns1.example.net"}}); err == nil {
+		t.Fatalf("expected a single custom nameserver to be rejected")
+	}
+	if _, err := client.SetNameservers(context.Background(), "example.com", Nameservers{Provider: NameserversBasic, Hosts: []string{"ignored"}}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got.Provider != NameserversBasic || len(got.Hosts) != 0 {
+		t.Fatalf("basic nameservers must not send hosts: %+v", got)
+	}
+}
@@ -0,0 +1,140 @@
+package spaceship
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/erkki/dnsupdater/internal/provider"
+)
+
+// maxErrorBody bounds how much of an error response is read.
+const maxErrorBody = 64 << 10
+
+// APIError is returned for every non-2xx response from the Spaceship API.
+// It matches provider.ErrConflict, ErrUnauthorized, ErrNotFound and
+// ErrRateLimited with errors.Is according to its status.
+type APIError struct {
+	StatusCode int
+	// Code and Message come from the JSON error body, when present.
+	Code    string
+	Message string
+	// Fields lists validation errors reported for individual fields.
+	Fields    []FieldError
+	Method    string
+	Path      string
+	RequestID string
+	// Body holds the raw response when it could not be parsed.
+	Body string
+}
+
+// FieldError is a validation error for one field of a request.
+type FieldError struct {
+	Field   string `json:"field"`
+	Code    string `json:"code"`
+	Message string `json:"details"`
+}
+
+func (e *APIError) Error() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "spaceship: %s %s: %d %s", e.Method, e.Path, e.StatusCode, http.StatusText(e.StatusCode))
+	switch {
+	case e.Message != "":
+		b.WriteString(": " + e.Message)
+	case e.Body != "":
+		b.WriteString(": " + e.Body)
+	}
+	if e.Code != "" {
+		fmt.Fprintf(&b, " (code %s)", e.Code)
+	}
+	for _, f := range e.Fields {
+		fmt.Fprintf(&b, "; %s: %s", f.Field, f.Message)
+	}
+	if e.RequestID != "" {
+		fmt.Fprintf(&b, " [request %s]", e.RequestID)
+	}
+	return b.String()
+}
+
+// Is reports whether the error's status corresponds to target.
+func (e *APIError) Is(target error) bool {
+	switch target {
+	case provider.ErrConflict:
+		return e.StatusCode == http.StatusConflict
+	case provider.ErrUnauthorized:
+		return e.StatusCode == http.StatusUnauthorized || e.StatusCode == http.StatusForbidden
+	case provider.ErrNotFound:
+		return e.StatusCode == http.StatusNotFound
+	case provider.ErrRateLimited:
+		return e.StatusCode == http.StatusTooManyRequests
+	}
+	return false
+}
+
+// IsUnauthorized reports whether err is an API error caused by rejected
+// credentials or missing permissions.
+func IsUnauthorized(err error) bool {
+	return errors.Is(err, provider.ErrUnauthorized)
+}
+
+// IsRateLimited reports whether err is an API error caused by exceeding a
+// rate limit.
+func IsRateLimited(err error) bool {
+	return errors.Is(err, provider.ErrRateLimited)
+}
+
+// IsNotFound reports whether err is an API error for a missing domain or
+// record.
+func IsNotFound(err error) bool {
+	return errors.Is(err, provider.ErrNotFound)
+}
+
+// newAPIError builds an APIError from a failed response, consuming its body.
+func newAPIError(req *http.Request, resp *http.Response) *APIError {
+	e := &APIError{
+		StatusCode: resp.StatusCode,
+		Method:     req.Method,
+		Path:       req.URL.Path,
+		RequestID:  requestID(resp.Header),
+	}
+	data, _ := io.ReadAll(io.LimitReader(resp.Body, maxErrorBody))
+
+	var body struct {
+		Detail  string       `json:"detail"`
+		Message string       `json:"message"`
+		Error   string       `json:"error"`
+		Code    any          `json:"code"`
+		Data    []FieldError `json:"data"`
+		Errors  []FieldError `json:"errors"`
+	}
+	if err := json.Unmarshal(data, &body); err != nil {
+		e.Body = strings.TrimSpace(string(data))
+		return e
+	}
+	for _, m := range []string{body.Detail, body.Message, body.Error} {
+		if m != "" {
+			e.Message = m
+			break
+		}
+	}
+	if body.Code != nil {
+		e.Code = fmt.Sprint(body.Code)
+	}
+	e.Fields = append(body.Data, body.Errors...)
+	if e.Message == "" && len(e.Fields) == 0 {
+		e.Body = strings.TrimSpace(string(data))
+	}
+	return e
+}
+
+func requestID(h http.Header) string {
+	for _, name := range []string{"X-Request-Id", "Spaceship-Request-Id", "X-Correlation-Id"} {
+		if v := h.Get(name); v != "" {
+			return v
+		}
+	}
+	return ""
+}
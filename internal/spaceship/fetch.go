@@ -0,0 +1,97 @@
+package spaceship
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"github.com/erkki/dnsupdater/internal/provider"
+)
+
+// FetchOptions controls how FetchRecords reads the records of the domains.
+type FetchOptions struct {
+	// Workers is the number of domains read at the same time. Values below 1
+	// read one domain at a time.
+	Workers int
+	// Partial keeps reading the other domains when one fails. Their records
+	// are returned together with a *provider.PartialError naming the failed
+	// domains. Otherwise the first failure cancels the remaining reads.
+	Partial bool
+}
+
+// DefaultFetchOptions returns the options used by NewClient.
+func DefaultFetchOptions() FetchOptions {
+	return FetchOptions{Workers: 4}
+}
+
+// SetFetchOptions replaces the client's fetch options.
+func (c *Client) SetFetchOptions(o FetchOptions) {
+	c.fetch = o
+}
+
+// FetchRecords returns the records of every domain in the account, in the
+// order the API lists the domains.
+func (c *Client) FetchRecords(ctx context.Context) ([]DNSRecord, error) {
+	domains, err := c.ListDomains(ctx, DomainFilter{})
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithCancelCause(ctx)
+	defer cancel(nil)
+
+	results := make([][]DNSRecord, len(domains))
+	errs := make([]error, len(domains))
+	jobs := make(chan int)
+	var wg sync.WaitGroup
+	for range min(max(c.fetch.Workers, 1), len(domains)) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				name := domains[i].Name
+				records, err := c.ListRecords(ctx, name)
+				if err != nil {
+					errs[i] = err
+					if !c.fetch.Partial {
+						cancel(fmt.Errorf("domain %s: %w", name, err))
+					}
+					continue
+				}
+				flat := make([]DNSRecord, len(records))
+				for j, record := range records {
+					flat[j] = record.Flatten(name)
+				}
+				results[i] = flat
+			}
+		}()
+	}
+feed:
+	for i := range domains {
+		select {
+		case jobs <- i:
+		case <-ctx.Done():
+			break feed
+		}
+	}
+	close(jobs)
+	wg.Wait()
+
+	// The cause is the first failure, or the caller's cancellation.
+	if err := context.Cause(ctx); err != nil {
+		return nil, err
+	}
+	var records []DNSRecord
+	partial := &provider.PartialError{Errs: make(map[string]error)}
+	for i, domain := range domains {
+		if errs[i] != nil {
+			partial.Errs[domain.Name] = errs[i]
+			continue
+		}
+		records = append(records, results[i]...)
+	}
+	if len(partial.Errs) > 0 {
+		return records, partial
+	}
+	return records, nil
+}
@@ -0,0 +1,165 @@
+package spaceship
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"path"
+	"strconv"
+)
+
+// ErrPagination is wrapped by the error of a Pager when the API's pages do
+// not add up: the total changes between pages, a page is empty before the
+// total is reached, more items arrive than the total announced, or a page
+// repeats the previous one.
+var ErrPagination = errors.New("spaceship: inconsistent pagination")
+
+// Pager streams the items of a paginated list endpoint, fetching one page at
+// a time with skip and take. Use it like bufio.Scanner:
+//
+//	pager := client.Records(ctx, "example.com")
+//	for pager.Next() {
+//		record := pager.Item()
+//		...
+//	}
+//	if err := pager.Err(); err != nil { ... }
+type Pager[T any] struct {
+	ctx      context.Context
+	client   *Client
+	endpoint string
+	query    url.Values
+	pageSize int
+
+	page  []json.RawMessage
+	last  []byte
+	pos   int
+	item  T
+	skip  int
+	total int
+	pages int
+	done  bool
+	err   error
+}
+
+// NewPager returns a pager over the "items" of endpoint, which answers with
+// {"items": [...], "total": n}. query holds extra parameters; the context is
+// checked before every page.
+func NewPager[T any](ctx context.Context, c *Client, endpoint string, query url.Values, pageSize int) *Pager[T] {
+	return &Pager[T]{ctx: ctx, client: c, endpoint: endpoint, query: query, pageSize: max(pageSize, 1)}
+}
+
+// Domains returns a pager over the domains of the account, sorted as given
+// by orderBy (see DomainFilter) or in the API's default order.
+func (c *Client) Domains(ctx context.Context, orderBy string) *Pager[Domain] {
+	query := url.Values{}
+	if orderBy != "" {
+		query.Set("orderBy", orderBy)
+	}
+	return NewPager[Domain](ctx, c, path.Join("v1", "domains"), query, domainPageSize)
+}
+
+// Records returns a pager over the records of a domain.
+func (c *Client) Records(ctx context.Context, domain string) *Pager[Record] {
+	return NewPager[Record](ctx, c, path.Join("v1", "dns", "records", domain), nil, recordPageSize)
+}
+
+// Next advances to the next item, fetching the next page when needed. It
+// returns false at the end of the list or on error.
+func (p *Pager[T]) Next() bool {
+	for p.pos >= len(p.page) {
+		if p.done || p.err != nil {
+			return false
+		}
+		if err := p.fetch(); err != nil {
+			p.err = err
+			return false
+		}
+	}
+	var item T
+	if err := json.Unmarshal(p.page[p.pos], &item); err != nil {
+		p.err = fmt.Errorf("decode %s item %d: %w", p.endpoint, p.skip-len(p.page)+p.pos, err)
+		return false
+	}
+	p.item = item
+	p.pos++
+	return true
+}
+
+// Item returns the current item.
+func (p *Pager[T]) Item() T {
+	return p.item
+}
+
+// Err returns the error that stopped Next, if any.
+func (p *Pager[T]) Err() error {
+	return p.err
+}
+
+// Total returns the number of items the API announced, once the first page
+// has been fetched.
+func (p *Pager[T]) Total() int {
+	return p.total
+}
+
+func (p *Pager[T]) fetch() error {
+	if err := p.ctx.Err(); err != nil {
+		return err
+	}
+	params := url.Values{}
+	for k, v := range p.query {
+		params[k] = v
+	}
+	params.Set("take", strconv.Itoa(p.pageSize))
+	params.Set("skip", strconv.Itoa(p.skip))
+
+	req, err := p.client.newRequest(p.ctx, http.MethodGet, p.endpoint, nil, params)
+	if err != nil {
+		return err
+	}
+	var payload struct {
+		Items []json.RawMessage `json:"items"`
+		Total int               `json:"total"`
+	}
+	if err := p.client.do(req, &payload); err != nil {
+		return err
+	}
+
+	if p.pages > 0 && payload.Total != p.total {
+		return fmt.Errorf("%w: %s total changed from %d to %d", ErrPagination, p.endpoint, p.total, payload.Total)
+	}
+	p.total = payload.Total
+	p.pages++
+	n := len(payload.Items)
+	if n == 0 {
+		if p.skip < p.total {
+			return fmt.Errorf("%w: %s returned an empty page at %d of %d items", ErrPagination, p.endpoint, p.skip, p.total)
+		}
+		p.done = true
+		return nil
+	}
+	// A server that ignores skip would otherwise be read until the total is
+	// reached, returning the same items over and over.
+	var content []byte
+	for _, item := range payload.Items {
+		content = append(append(content, item...), ',')
+	}
+	if bytes.Equal(content, p.last) {
+		return fmt.Errorf("%w: %s returned the same page at %d as at %d", ErrPagination, p.endpoint, p.skip, p.skip-n)
+	}
+	p.last = content
+	p.page = payload.Items
+	p.pos = 0
+	p.skip += n
+	if p.total > 0 && p.skip > p.total {
+		return fmt.Errorf("%w: %s returned %d items, more than its total of %d", ErrPagination, p.endpoint, p.skip, p.total)
+	}
+	// Without a total only one page is read.
+	if p.skip >= p.total {
+		p.done = true
+	}
+	return nil
+}
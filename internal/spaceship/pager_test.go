@@ -0,0 +1,127 @@
+package spaceship
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+)
+
+// pagedServer serves /items, answering each request with page(skip, take).
+func pagedServer(t *testing.T, page func(skip, take int) (items []int, total int)) (*Client, *atomic.Int32) {
+	t.Helper()
+	var requests atomic.Int32
+	mux := http.NewServeMux()
+	mux.HandleFunc("/items", func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		skip, _ := strconv.Atoi(r.URL.Query().Get("skip"))
+		take, _ := strconv.Atoi(r.URL.Query().Get("take"))
+		items, total := page(skip, take)
+		parts := make([]string, len(items))
+		for i, item := range items {
+			parts[i] = fmt.Sprintf(`{"n":%d}`, item)
+		}
+		fmt.Fprintf(w, `{"items":[%s],"total":%d}`, strings.Join(parts, ","), total)
+	})
+	srv := httptest.NewServer(mux)
+	t.Cleanup(srv.Close)
+	return NewClient(srv.URL, "key", "secret", srv.Client()), &requests
+}
+
+type pagedItem struct {
+	N int `json:"n"`
+}
+
+// slice serves the numbers 0..n-1.
+func slice(n int) func(skip, take int) ([]int, int) {
+	return func(skip, take int) ([]int, int) {
+		var items []int
+		for i := skip; i < n && i < skip+take; i++ {
+			items = append(items, i)
+		}
+		return items, n
+	}
+}
+
+func collect(p *Pager[pagedItem]) []int {
+	var got []int
+	for p.Next() {
+		got = append(got, p.Item().N)
+	}
+	return got
+}
+
+func TestPagerStreamsPages(t *testing.T) {
+	client, requests := pagedServer(t, slice(5))
+	pager := NewPager[pagedItem](context.Background(), client, "items", nil, 2)
+	got := collect(pager)
+	if err := pager.Err(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if fmt.Sprint(got) != "[0 1 2 3 4]" || pager.Total() != 5 {
+		t.Fatalf("unexpected items %v, total %d", got, pager.Total())
+	}
+	if n := requests.Load(); n != 3 {
+		t.Fatalf("expected 3 pages, got %d", n)
+	}
+}
+
+func TestPagerDetectsInconsistentPages(t *testing.T) {
+	tests := map[string]func(skip, take int) ([]int, int){
+		"total changes": func(skip, take int) ([]int, int) {
+			items, total := slice(6)(skip, take)
+			if skip > 0 {
+				total = 4
+			}
+			return items, total
+		},
+		"empty page before total": func(skip, take int) ([]int, int) {
+			if skip > 0 {
+				return nil, 6
+			}
+			return slice(6)(skip, take)
+		},
+		"skip ignored": func(skip, take int) ([]int, int) {
+			return slice(6)(0, take)
+		},
+		"more items than total": func(skip, take int) ([]int, int) {
+			items, _ := slice(6)(skip, take)
+			return items, 3
+		},
+	}
+	for name, page := range tests {
+		client, requests := pagedServer(t, page)
+		pager := NewPager[pagedItem](context.Background(), client, "items", nil, 2)
+		collect(pager)
+		if !errors.Is(pager.Err(), ErrPagination) {
+			t.Errorf("%s: expected a pagination error, got %v", name, pager.Err())
+		}
+		if n := requests.Load(); n > 3 {
+			t.Errorf("%s: kept reading after the inconsistency, %d requests", name, n)
+		}
+	}
+}
+
+func TestPagerStopsWhenCancelled(t *testing.T) {
+	client, requests := pagedServer(t, slice(10))
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	pager := NewPager[pagedItem](ctx, client, "items", nil, 2)
+	var got []int
+	for pager.Next() {
+		got = append(got, pager.Item().N)
+		cancel()
+	}
+	if !errors.Is(pager.Err(), context.Canceled) {
+		t.Fatalf("expected cancellation, got %v", pager.Err())
+	}
+	// The page already fetched is finished; no further page is requested.
+	if len(got) != 2 || requests.Load() != 1 {
+		t.Fatalf("expected one page, got items %v after %d requests", got, requests.Load())
+	}
+}
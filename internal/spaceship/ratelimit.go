@@ -0,0 +1,209 @@
+package spaceship
+
+import (
+	"context"
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Limit is a token bucket: Rate requests per second on average, with bursts
+// of up to Burst requests. A zero Rate disables limiting.
+type Limit struct {
+	Rate  float64
+	Burst int
+}
+
+// RateLimits holds the limits of each endpoint class. List covers reads,
+// Write covers creates, updates and deletes.
+type RateLimits struct {
+	List  Limit
+	Write Limit
+}
+
+// DefaultRateLimits returns the limits used by NewClient.
+func DefaultRateLimits() RateLimits {
+	return RateLimits{
+		List:  Limit{Rate: 5, Burst: 10},
+		Write: Limit{Rate: 2, Burst: 4},
+	}
+}
+
+// LimiterStats reports how much one endpoint class was throttled.
+type LimiterStats struct {
+	// Requests counts the requests that passed the limiter.
+	Requests int64
+	// Waits counts the requests that had to wait, for Waited in total.
+	Waits  int64
+	Waited time.Duration
+}
+
+// RateLimitStats reports the throttling of each endpoint class.
+type RateLimitStats struct {
+	List  LimiterStats
+	Write LimiterStats
+}
+
+// SetRateLimits replaces the client's rate limits.
+func (c *Client) SetRateLimits(limits RateLimits) {
+	c.limits.list.setLimit(limits.List)
+	c.limits.write.setLimit(limits.Write)
+}
+
+// RateLimitStats returns the time spent waiting for the rate limiters since
+// the client was created.
+func (c *Client) RateLimitStats() RateLimitStats {
+	return RateLimitStats{List: c.limits.list.stats(), Write: c.limits.write.stats()}
+}
+
+type limiters struct {
+	list  *limiter
+	write *limiter
+}
+
+func newLimiters(limits RateLimits) limiters {
+	return limiters{list: newLimiter(limits.List), write: newLimiter(limits.Write)}
+}
+
+// forMethod returns the limiter of the endpoint class of an HTTP method.
+func (l limiters) forMethod(method string) *limiter {
+	if method == http.MethodGet || method == http.MethodHead {
+		return l.list
+	}
+	return l.write
+}
+
+// limiter is a token bucket shared by every request of one endpoint class.
+// It can be paused when the API reports that the quota is used up.
+type limiter struct {
+	mu          sync.Mutex
+	limit       Limit
+	tokens      float64
+	last        time.Time
+	pausedUntil time.Time
+	st          LimiterStats
+}
+
+func newLimiter(limit Limit) *limiter {
+	l := &limiter{}
+	l.setLimit(limit)
+	return l
+}
+
+func (l *limiter) setLimit(limit Limit) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if limit.Burst < 1 {
+		limit.Burst = 1
+	}
+	l.limit = limit
+	l.tokens = float64(limit.Burst)
+	l.last = time.Now()
+}
+
+// wait blocks until a request may be sent.
+func (l *limiter) wait(ctx context.Context) error {
+	l.mu.Lock()
+	now := time.Now()
+	var delay time.Duration
+	if l.limit.Rate > 0 {
+		l.refill(now)
+		// Reserve a token; a negative balance is paid back by waiting.
+		l.tokens--
+		if l.tokens < 0 {
+			delay = time.Duration(-l.tokens / l.limit.Rate * float64(time.Second))
+		}
+	}
+	if pause := l.pausedUntil.Sub(now); pause > delay {
+		delay = pause
+	}
+	l.st.Requests++
+	if delay > 0 {
+		l.st.Waits++
+		l.st.Waited += delay
+	}
+	l.mu.Unlock()
+
+	if err := sleep(ctx, delay); err != nil {
+		l.mu.Lock()
+		if l.limit.Rate > 0 {
+			l.tokens++
+		}
+		l.mu.Unlock()
+		return err
+	}
+	return nil
+}
+
+func (l *limiter) refill(now time.Time) {
+	elapsed := now.Sub(l.last).Seconds()
+	l.last = now
+	l.tokens = math.Min(float64(l.limit.Burst), l.tokens+elapsed*l.limit.Rate)
+}
+
+// observe slows the limiter down according to the rate-limit headers of a
+// response: when the remaining quota is exhausted, or the API answered 429,
+// every request of the class waits until the quota resets.
+func (l *limiter) observe(resp *http.Response) {
+	now := time.Now()
+	remaining, hasRemaining := headerInt(resp.Header, "X-RateLimit-Remaining", "RateLimit-Remaining")
+	reset, hasReset := resetAfter(resp.Header, now)
+
+	var until time.Time
+	switch {
+	case resp.StatusCode == http.StatusTooManyRequests:
+		wait, ok := retryAfter(resp)
+		if !ok {
+			wait = reset
+		}
+		if wait <= 0 {
+			wait = time.Second
+		}
+		until = now.Add(wait)
+	case hasRemaining && remaining <= 0 && hasReset:
+		until = now.Add(reset)
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if until.After(l.pausedUntil) {
+		l.pausedUntil = until
+	}
+	// Never hold more tokens than the API says are left.
+	if hasRemaining && l.limit.Rate > 0 {
+		l.refill(now)
+		l.tokens = math.Min(l.tokens, float64(remaining))
+	}
+}
+
+func (l *limiter) stats() LimiterStats {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.st
+}
+
+func headerInt(h http.Header, names ...string) (int, bool) {
+	for _, name := range names {
+		if v := h.Get(name); v != "" {
+			n, err := strconv.Atoi(v)
+			return n, err == nil
+		}
+	}
+	return 0, false
+}
+
+// resetAfter parses the time until the quota resets, given either as seconds
+// or as a Unix timestamp.
+func resetAfter(h http.Header, now time.Time) (time.Duration, bool) {
+	n, ok := headerInt(h, "X-RateLimit-Reset", "RateLimit-Reset")
+	if !ok || n < 0 {
+		return 0, false
+	}
+	// Values beyond a day are timestamps rather than delays.
+	if n > 86400 {
+		return max(time.Unix(int64(n), 0).Sub(now), 0), true
+	}
+	return time.Duration(n) * time.Second, true
+}
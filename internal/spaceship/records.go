@@ -0,0 +1,295 @@
+package spaceship
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// RecordTypes lists the record types Spaceship supports.
+var RecordTypes = []string{"A", "AAAA", "ALIAS", "CAA", "CNAME", "HTTPS", "MX", "NS", "PTR", "SRV", "SVCB", "TLSA", "TXT"}
+
+// Record is a DNS record in the Spaceship API's own representation. Only the
+// fields of its Type are used; MarshalJSON encodes exactly those. A zero TTL
+// is omitted, as in delete requests.
+type Record struct {
+	Type string `json:"type"`
+	Name string `json:"name"`
+	TTL  int    `json:"ttl,omitempty"`
+
+	// A and AAAA.
+	Address string `json:"address,omitempty"`
+	// ALIAS.
+	AliasName string `json:"aliasName,omitempty"`
+	// CAA, with Value.
+	Flag int    `json:"flag,omitempty"`
+	Tag  string `json:"tag,omitempty"`
+	// CAA and TXT.
+	Value string `json:"value,omitempty"`
+	// CNAME.
+	CName string `json:"cname,omitempty"`
+	// HTTPS and SVCB.
+	SvcPriority int    `json:"svcPriority,omitempty"`
+	TargetName  string `json:"targetName,omitempty"`
+	SvcParams   string `json:"svcParams,omitempty"`
+	// MX.
+	Exchange   string `json:"exchange,omitempty"`
+	Preference int    `json:"preference,omitempty"`
+	// NS.
+	Nameserver string `json:"nameserver,omitempty"`
+	// PTR.
+	Pointer string `json:"pointer,omitempty"`
+	// SRV. Name is the host the service runs on, without the service and
+	// protocol labels.
+	Service  string `json:"service,omitempty"`
+	Protocol string `json:"protocol,omitempty"`
+	Priority int    `json:"priority,omitempty"`
+	Weight   int    `json:"weight,omitempty"`
+	Port     int    `json:"port,omitempty"`
+	Target   string `json:"target,omitempty"`
+	// TLSA. Name carries the port and protocol labels, e.g. "_443._tcp.www".
+	Usage           int    `json:"usage,omitempty"`
+	Selector        int    `json:"selector,omitempty"`
+	Matching        int    `json:"matching,omitempty"`
+	AssociationData string `json:"associationData,omitempty"`
+}
+
+// recordHeader holds the fields every record type shares.
+type recordHeader struct {
+	Type string `json:"type"`
+	Name string `json:"name"`
+	TTL  int    `json:"ttl,omitempty"`
+}
+
+// MarshalJSON encodes the record with the fields of its type only. Numeric
+// fields are always written, since zero is a valid preference, weight or flag.
+func (r Record) MarshalJSON() ([]byte, error) {
+	h := recordHeader{Type: r.Type, Name: r.Name, TTL: r.TTL}
+	switch r.Type {
+	case "A", "AAAA":
+		return json.Marshal(struct {
+			recordHeader
+			Address string `json:"address"`
+		}{h, r.Address})
+	case "ALIAS":
+		return json.Marshal(struct {
+			recordHeader
+			AliasName string `json:"aliasName"`
+		}{h, r.AliasName})
+	case "CAA":
+		return json.Marshal(struct {
+			recordHeader
+			Flag  int    `json:"flag"`
+			Tag   string `json:"tag"`
+			Value string `json:"value"`
+		}{h, r.Flag, r.Tag, r.Value})
+	case "CNAME":
+		return json.Marshal(struct {
+			recordHeader
+			CName string `json:"cname"`
+		}{h, r.CName})
+	case "HTTPS", "SVCB":
+		return json.Marshal(struct {
+			recordHeader
+			SvcPriority int    `json:"svcPriority"`
+			TargetName  string `json:"targetName"`
+			SvcParams   string `json:"svcParams"`
+		}{h, r.SvcPriority, r.TargetName, r.SvcParams})
+	case "MX":
+		return json.Marshal(struct {
+			recordHeader
+			Exchange   string `json:"exchange"`
+			Preference int    `json:"preference"`
+		}{h, r.Exchange, r.Preference})
+	case "NS":
+		return json.Marshal(struct {
+			recordHeader
+			Nameserver string `json:"nameserver"`
+		}{h, r.Nameserver})
+	case "PTR":
+		return json.Marshal(struct {
+			recordHeader
+			Pointer string `json:"pointer"`
+		}{h, r.Pointer})
+	case "SRV":
+		return json.Marshal(struct {
+			recordHeader
+			Service  string `json:"service"`
+			Protocol string `json:"protocol"`
+			Priority int    `json:"priority"`
+			Weight   int    `json:"weight"`
+			Port     int    `json:"port"`
+			Target   string `json:"target"`
+		}{h, r.Service, r.Protocol, r.Priority, r.Weight, r.Port, r.Target})
+	case "TLSA":
+		return json.Marshal(struct {
+			recordHeader
+			Usage           int    `json:"usage"`
+			Selector        int    `json:"selector"`
+			Matching        int    `json:"matching"`
+			AssociationData string `json:"associationData"`
+		}{h, r.Usage, r.Selector, r.Matching, r.AssociationData})
+	case "TXT":
+		return json.Marshal(struct {
+			recordHeader
+			Value string `json:"value"`
+		}{h, r.Value})
+	}
+	return nil, fmt.Errorf("spaceship: unsupported record type %s", r.Type)
+}
+
+// Content returns the record value in presentation format, e.g.
+// "10 mail.example.com" for MX or `0 issue "letsencrypt.org"` for CAA. TXT
+// values are returned as is, without quotes.
+func (r Record) Content() string {
+	switch r.Type {
+	case "A", "AAAA":
+		return r.Address
+	case "ALIAS":
+		return r.AliasName
+	case "CAA":
+		return fmt.Sprintf("%d %s %q", r.Flag, r.Tag, r.Value)
+	case "CNAME":
+		return r.CName
+	case "HTTPS", "SVCB":
+		return strings.TrimSpace(fmt.Sprintf("%d %s %s", r.SvcPriority, r.TargetName, r.SvcParams))
+	case "MX":
+		return fmt.Sprintf("%d %s", r.Preference, r.Exchange)
+	case "NS":
+		return r.Nameserver
+	case "PTR":
+		return r.Pointer
+	case "SRV":
+		return fmt.Sprintf("%d %d %d %s", r.Priority, r.Weight, r.Port, r.Target)
+	case "TLSA":
+		return fmt.Sprintf("%d %d %d %s", r.Usage, r.Selector, r.Matching, r.AssociationData)
+	case "TXT":
+		return r.Value
+	}
+	return ""
+}
+
+// Flatten converts the record to the provider-independent view of domain. SRV
+// records get the service and protocol labels prepended to their name.
+func (r Record) Flatten(domain string) DNSRecord {
+	name := r.Name
+	if r.Type == "SRV" {
+		name = r.Service + "." + r.Protocol
+		if r.Name != "" && r.Name != "@" {
+			name += "." + r.Name
+		}
+	}
+	return DNSRecord{Domain: domain, Name: name, Type: r.Type, Content: r.Content(), TTL: r.TTL}
+}
+
+// ParseRecord converts a provider-independent record, with its content in
+// presentation format, to the Spaceship representation. It is the inverse of
+// Flatten.
+func ParseRecord(record DNSRecord) (Record, error) {
+	r := Record{Type: strings.ToUpper(record.Type), Name: record.Name, TTL: record.TTL}
+	content := strings.TrimSpace(record.Content)
+	var err error
+	switch r.Type {
+	case "A", "AAAA":
+		r.Address = content
+	case "ALIAS":
+		r.AliasName = content
+	case "CAA":
+		var fields []string
+		if fields, err = splitFields(content, 3, "flag tag value"); err == nil {
+			r.Tag = fields[1]
+			r.Value = unquote(fields[2])
+			r.Flag, err = strconv.Atoi(fields[0])
+		}
+	case "CNAME":
+		r.CName = content
+	case "HTTPS", "SVCB":
+		fields := strings.SplitN(content, " ", 3)
+		if len(fields) < 2 {
+			err = fmt.Errorf("want \"priority target [params]\"")
+			break
+		}
+		r.TargetName = fields[1]
+		if len(fields) == 3 {
+			r.SvcParams = strings.TrimSpace(fields[2])
+		}
+		r.SvcPriority, err = strconv.Atoi(fields[0])
+	case "MX":
+		var fields []string
+		if fields, err = splitFields(content, 2, "preference exchange"); err == nil {
+			r.Exchange = fields[1]
+			r.Preference, err = strconv.Atoi(fields[0])
+		}
+	case "NS":
+		r.Nameserver = content
+	case "PTR":
+		r.Pointer = content
+	case "SRV":
+		err = parseSRV(&r, content)
+	case "TLSA":
+		var fields []string
+		if fields, err = splitFields(content, 4, "usage selector matching data"); err == nil {
+			r.AssociationData = fields[3]
+			err = atoiAll(fields[:3], &r.Usage, &r.Selector, &r.Matching)
+		}
+	case "TXT":
+		r.Value = record.Content
+	default:
+		return r, fmt.Errorf("spaceship: unsupported record type %s", record.Type)
+	}
+	if err != nil {
+		return r, fmt.Errorf("invalid %s content %q: %w", r.Type, record.Content, err)
+	}
+	return r, nil
+}
+
+// parseSRV fills the SRV fields of r from its content and its flattened
+// name, "_service._protocol[.host]".
+func parseSRV(r *Record, content string) error {
+	labels := strings.SplitN(r.Name, ".", 3)
+	if len(labels) < 2 || !strings.HasPrefix(labels[0], "_") || !strings.HasPrefix(labels[1], "_") {
+		return fmt.Errorf("name %q does not start with _service._protocol", r.Name)
+	}
+	r.Service, r.Protocol, r.Name = labels[0], labels[1], "@"
+	if len(labels) == 3 {
+		r.Name = labels[2]
+	}
+	fields, err := splitFields(content, 4, "priority weight port target")
+	if err != nil {
+		return err
+	}
+	r.Target = fields[3]
+	return atoiAll(fields[:3], &r.Priority, &r.Weight, &r.Port)
+}
+
+// splitFields splits content into exactly n space-separated fields, the last
+// one taking the rest of the content.
+func splitFields(content string, n int, format string) ([]string, error) {
+	fields := strings.Fields(content)
+	if len(fields) < n {
+		return nil, fmt.Errorf("want %q", format)
+	}
+	if len(fields) > n {
+		fields[n-1] = strings.Join(fields[n-1:], " ")
+	}
+	return fields[:n], nil
+}
+
+func atoiAll(fields []string, dst ...*int) error {
+	for i, field := range fields {
+		n, err := strconv.Atoi(field)
+		if err != nil {
+			return err
+		}
+		*dst[i] = n
+	}
+	return nil
+}
+
+func unquote(s string) string {
+	if v, err := strconv.Unquote(s); err == nil {
+		return v
+	}
+	return s
+}
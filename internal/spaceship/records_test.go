@@ -0,0 +1,80 @@
+package spaceship
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestRecordRoundTrip(t *testing.T) {
+	tests := []struct {
+		json    string
+		name    string
+		content string
+	}{
+		{`{"type":"A","name":"@","ttl":300,"address":"203.0.113.7"}`, "@", "203.0.113.7"},
+		{`{"type":"AAAA","name":"www","ttl":300,"address":"2001:db8::1"}`, "www", "2001:db8::1"},
+		{`{"type":"ALIAS","name":"@","ttl":300,"aliasName":"lb.example.net"}`, "@", "lb.example.net"},
+		{`{"type":"CAA","name":"@","ttl":3600,"flag":0,"tag":"issue","value":"letsencrypt.org"}`, "@", `0 issue "letsencrypt.org"`},
+		{`{"type":"CNAME","name":"www","ttl":300,"cname":"example.com"}`, "www", "example.com"},
+		{`{"type":"HTTPS","name":"@","ttl":300,"svcPriority":1,"targetName":".","svcParams":"alpn=h2,h3"}`, "@", "1 . alpn=h2,h3"},
+		{`{"type":"MX","name":"@","ttl":3600,"exchange":"mail.example.com","preference":0}`, "@", "0 mail.example.com"},
+		{`{"type":"NS","name":"sub","ttl":3600,"nameserver":"ns1.example.net"}`, "sub", "ns1.example.net"},
+		{`{"type":"PTR","name":"7","ttl":3600,"pointer":"host.example.com"}`, "7", "host.example.com"},
+		{`{"type":"SRV","name":"@","ttl":600,"service":"_sip","protocol":"_tcp","priority":10,"weight":0,"port":5060,"target":"sip.example.com"}`, "_sip._tcp", "10 0 5060 sip.example.com"},
+		{`{"type":"SRV","name":"eu","ttl":600,"service":"_xmpp","protocol":"_tcp","priority":5,"weight":20,"port":5222,"target":"xmpp.example.com"}`, "_xmpp._tcp.eu", "5 20 5222 xmpp.example.com"},
+		{`{"type":"SVCB","name":"_dns","ttl":300,"svcPriority":0,"targetName":"dns.example.com","svcParams":""}`, "_dns", "0 dns.example.com"},
+		{`{"type":"TLSA","name":"_443._tcp.www","ttl":3600,"usage":3,"selector":1,"matching":1,"associationData":"abcdef0123"}`, "_443._tcp.www", "3 1 1 abcdef0123"},
+		{`{"type":"TXT","name":"@","ttl":300,"value":"v=spf1 include:_spf.example.net -all"}`, "@", "v=spf1 include:_spf.example.net -all"},
+	}
+	for _, tt := range tests {
+		var record Record
+		if err := json.Unmarshal([]byte(tt.json), &record); err != nil {
+			t.Fatalf("decode %s: %v", tt.json, err)
+		}
+		flat := record.Flatten("example.com")
+		if flat.Domain != "example.com" || flat.Name != tt.name || flat.Content != tt.content {
+			t.Errorf("%s: flattened to %+v, want name %q content %q", record.Type, flat, tt.name, tt.content)
+			continue
+		}
+
+		parsed, err := ParseRecord(flat)
+		if err != nil {
+			t.Errorf("%s: parse %q: %v", record.Type, flat.Content, err)
+			continue
+		}
+		if parsed != record {
+			t.Errorf("%s: parsed %+v, want %+v", record.Type, parsed, record)
+		}
+
+		// List and create share the encoding; deletes leave out the TTL.
+		data, err := json.Marshal(parsed)
+		if err != nil {
+			t.Fatalf("%s: encode: %v", record.Type, err)
+		}
+		if string(data) != tt.json {
+			t.Errorf("%s: encoded as\n%s\nwant\n%s", record.Type, data, tt.json)
+		}
+		parsed.TTL = 0
+		data, _ = json.Marshal(parsed)
+		var fields map[string]any
+		json.Unmarshal(data, &fields)
+		if _, ok := fields["ttl"]; ok {
+			t.Errorf("%s: delete form carries a TTL: %s", record.Type, data)
+		}
+	}
+}
+
+func TestParseRecordRejectsMalformedContent(t *testing.T) {
+	for _, record := range []DNSRecord{
+		{Name: "@", Type: "MX", Content: "mail"},
+		{Name: "@", Type: "CAA", Content: "x issue ca.example"},
+		{Name: "@", Type: "SRV", Content: "10 0 5060 sip.example.com"},
+		{Name: "_sip._tcp", Type: "SRV", Content: "10 0 sip.example.com"},
+		{Name: "@", Type: "TLSA", Content: "3 1 abcdef"},
+		{Name: "@", Type: "SOA", Content: "ns1 hostmaster 1 2 3 4 5"},
+	} {
+		if _, err := ParseRecord(record); err == nil {
+			t.Errorf("expected %s %q to be rejected", record.Type, record.Content)
+		}
+	}
+}
@@ -0,0 +1,164 @@
+package spaceship
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"math/rand/v2"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// RetryPolicy controls how requests that fail transiently are retried.
+type RetryPolicy struct {
+	// MaxAttempts is the total number of tries, including the first. Values
+	// below 2 disable retries.
+	MaxAttempts int
+	// BaseDelay is the wait before the first retry; it doubles on every
+	// further retry up to MaxDelay, with jitter.
+	BaseDelay time.Duration
+	MaxDelay  time.Duration
+	// MaxRetryAfter caps the wait requested by a Retry-After header. Longer
+	// requests are not waited for and the response is returned as is.
+	MaxRetryAfter time.Duration
+}
+
+// DefaultRetryPolicy returns the policy used by NewClient.
+func DefaultRetryPolicy() RetryPolicy {
+	return RetryPolicy{
+		MaxAttempts:   4,
+		BaseDelay:     500 * time.Millisecond,
+		MaxDelay:      10 * time.Second,
+		MaxRetryAfter: 2 * time.Minute,
+	}
+}
+
+// SetRetryPolicy replaces the client's retry policy.
+func (c *Client) SetRetryPolicy(p RetryPolicy) {
+	c.retry = p
+}
+
+// send performs req once the rate limiter of its endpoint class allows it,
+// retrying transport errors and 429, 502, 503 and 504
+// responses according to the retry policy. Only idempotent requests are
+// retried, so a write that may have reached the API is never repeated unless
+// repeating it is harmless.
+func (c *Client) send(req *http.Request) (*http.Response, error) {
+	attempts := c.retry.MaxAttempts
+	if attempts < 1 || !idempotent(req.Method) {
+		attempts = 1
+	}
+	for attempt := 1; ; attempt++ {
+		if attempt > 1 && req.GetBody != nil {
+			body, err := req.GetBody()
+			if err != nil {
+				return nil, err
+			}
+			req.Body = body
+		}
+		limiter := c.limits.forMethod(req.Method)
+		if err := limiter.wait(req.Context()); err != nil {
+			return nil, err
+		}
+		resp, err := c.http.Do(req)
+		if err == nil {
+			limiter.observe(resp)
+		}
+		if attempt >= attempts {
+			return resp, err
+		}
+
+		var wait time.Duration
+		switch {
+		case err != nil:
+			if req.Context().Err() != nil {
+				return nil, err
+			}
+			wait = c.retry.backoff(attempt)
+		case retryableStatus(resp.StatusCode):
+			wait = c.retry.backoff(attempt)
+			if after, ok := retryAfter(resp); ok {
+				if after > c.retry.MaxRetryAfter {
+					return resp, nil
+				}
+				wait = after
+			}
+			// Drain so the connection can be reused.
+			io.Copy(io.Discard, resp.Body)
+			resp.Body.Close()
+		default:
+			return resp, nil
+		}
+
+		if err := sleep(req.Context(), wait); err != nil {
+			return nil, fmt.Errorf("%s %s: giving up after %d attempts: %w", req.Method, req.URL.Path, attempt, err)
+		}
+	}
+}
+
+// backoff returns the wait before retry number attempt (starting at 1):
+// an exponentially growing delay of which a random half is jitter.
+func (p RetryPolicy) backoff(attempt int) time.Duration {
+	d := p.BaseDelay
+	for i := 1; i < attempt && d < p.MaxDelay; i++ {
+		d *= 2
+	}
+	if p.MaxDelay > 0 && d > p.MaxDelay {
+		d = p.MaxDelay
+	}
+	if d <= 0 {
+		return 0
+	}
+	half := d / 2
+	return half + rand.N(half+1)
+}
+
+func idempotent(method string) bool {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodPut, http.MethodDelete, http.MethodOptions:
+		return true
+	}
+	return false
+}
+
+func retryableStatus(code int) bool {
+	switch code {
+	case http.StatusTooManyRequests, http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return true
+	}
+	return false
+}
+
+// retryAfter parses the Retry-After header of 429 and 503 responses, given
+// either in seconds or as an HTTP date.
+func retryAfter(resp *http.Response) (time.Duration, bool) {
+	if resp.StatusCode != http.StatusTooManyRequests && resp.StatusCode != http.StatusServiceUnavailable {
+		return 0, false
+	}
+	v := resp.Header.Get("Retry-After")
+	if v == "" {
+		return 0, false
+	}
+	if secs, err := strconv.Atoi(v); err == nil && secs >= 0 {
+		return time.Duration(secs) * time.Second, true
+	}
+	if t, err := http.ParseTime(v); err == nil {
+		return max(time.Until(t), 0), true
+	}
+	return 0, false
+}
+
+func sleep(ctx context.Context, d time.Duration) error {
+	if d <= 0 {
+		return ctx.Err()
+	}
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-t.C:
+		return nil
+	}
+}
@@ -0,0 +1,468 @@
+// Package spaceshiptest provides an in-process fake of the Spaceship domains
+// and DNS records API for tests.
+//
+// The fake keeps its domains and records in memory and implements the
+// endpoints used by spaceship.Client: domain listing and details, nameserver
+// changes and record listing, writing and deletion. It checks credentials,
+// paginates with skip and take, validates TTLs and can be told to fail or
+// slow down requests.
+package spaceshiptest
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/erkki/dnsupdater/internal/spaceship"
+)
+
+// Credentials accepted by the fake.
+const (
+	APIKey    = "test-key"
+	APISecret = "test-secret"
+)
+
+// Limits enforced by the fake, as by the real API.
+const (
+	MaxDomainsTake = 100
+	MaxRecordsTake = 500
+	MinTTL         = 60
+	MaxTTL         = 3600
+)
+
+// Failure makes matching requests fail with Status instead of being served.
+type Failure struct {
+	// Method and Path select the requests; empty values match any. Path
+	// matches as a prefix, e.g. "/v1/dns/records/".
+	Method string
+	Path   string
+	Status int
+	// Header is added to the response, e.g. Retry-After.
+	Header http.Header
+	// Times is how many requests fail; 0 means every one.
+	Times int
+}
+
+// Request is a request the fake received.
+type Request struct {
+	Method string
+	Path   string
+	Query  string
+}
+
+// Server is a running fake Spaceship API.
+type Server struct {
+	*httptest.Server
+
+	mu       sync.Mutex
+	domains  map[string]*domain
+	failures []*Failure
+	latency  time.Duration
+	requests []Request
+}
+
+type domain struct {
+	info    spaceship.Domain
+	records []spaceship.Record
+}
+
+// NewServer starts a fake with no domains. It is closed when the test ends.
+func NewServer(tb testing.TB) *Server {
+	tb.Helper()
+	s := &Server{domains: make(map[string]*domain)}
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /v1/domains", s.listDomains)
+	mux.HandleFunc("GET /v1/domains/{domain}", s.getDomain)
+	mux.HandleFunc("PUT /v1/domains/{domain}/nameservers", s.setNameservers)
+	mux.HandleFunc("GET /v1/dns/records/{domain}", s.listRecords)
+	mux.HandleFunc("PUT /v1/dns/records/{domain}", s.putRecords)
+	mux.HandleFunc("DELETE /v1/dns/records/{domain}", s.deleteRecords)
+	s.Server = httptest.NewServer(s.middleware(mux))
+	tb.Cleanup(s.Close)
+	return s
+}
+
+// Client returns a client for the fake with the valid credentials, no rate
+// limits and retries that do not wait.
+func (s *Server) Client() *spaceship.Client {
+	client := spaceship.NewClient(s.URL, APIKey, APISecret, s.Server.Client())
+	client.SetRateLimits(spaceship.RateLimits{})
+	client.SetRetryPolicy(spaceship.RetryPolicy{MaxAttempts: 3, BaseDelay: time.Millisecond, MaxDelay: time.Millisecond, MaxRetryAfter: time.Second})
+	return client
+}
+
+// AddDomain adds a domain holding records, replacing any domain of the same
+// name. The nameservers default to Spaceship's own.
+func (s *Server) AddDomain(info spaceship.Domain, records ...spaceship.Record) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if info.Nameservers.Provider == "" {
+		info.Nameservers.Provider = spaceship.NameserversBasic
+	}
+	s.domains[strings.ToLower(info.Name)] = &domain{info: info, records: append([]spaceship.Record(nil), records...)}
+}
+
+// RemoveDomain removes a domain and its records.
+func (s *Server) RemoveDomain(name string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.domains, strings.ToLower(name))
+}
+
+// Domain returns the current details of a domain.
+func (s *Server) Domain(name string) (spaceship.Domain, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	d, ok := s.domains[strings.ToLower(name)]
+	if !ok {
+		return spaceship.Domain{}, false
+	}
+	return d.info, true
+}
+
+// Records returns the current records of a domain, in creation order.
+func (s *Server) Records(name string) []spaceship.Record {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	d, ok := s.domains[strings.ToLower(name)]
+	if !ok {
+		return nil
+	}
+	return append([]spaceship.Record(nil), d.records...)
+}
+
+// SetRecords replaces the records of an existing domain, e.g. to simulate an
+// edit made in the dashboard.
+func (s *Server) SetRecords(name string, records ...spaceship.Record) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if d, ok := s.domains[strings.ToLower(name)]; ok {
+		d.records = append([]spaceship.Record(nil), records...)
+	}
+}
+
+// Fail registers a failure. Failures are checked in the order they were
+// registered, after authentication.
+func (s *Server) Fail(f Failure) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.failures = append(s.failures, &f)
+}
+
+// SetLatency delays every response by d.
+func (s *Server) SetLatency(d time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.latency = d
+}
+
+// Requests returns the requests received so far, including rejected ones.
+func (s *Server) Requests() []Request {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]Request(nil), s.requests...)
+}
+
+// Writes returns the received requests that change state.
+func (s *Server) Writes() []Request {
+	var writes []Request
+	for _, r := range s.Requests() {
+		if r.Method != http.MethodGet {
+			writes = append(writes, r)
+		}
+	}
+	return writes
+}
+
+func (s *Server) middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s.mu.Lock()
+		s.requests = append(s.requests, Request{Method: r.Method, Path: r.URL.Path, Query: r.URL.RawQuery})
+		latency := s.latency
+		s.mu.Unlock()
+
+		if latency > 0 {
+			t := time.NewTimer(latency)
+			select {
+			case <-r.Context().Done():
+				t.Stop()
+				return
+			case <-t.C:
+			}
+		}
+		if r.Header.Get("X-Api-Key") != APIKey || r.Header.Get("X-Api-Secret") != APISecret {
+			writeError(w, http.StatusUnauthorized, "invalid API key or secret")
+			return
+		}
+		// Rejected requests do not use up an injected failure.
+		s.mu.Lock()
+		failure := s.failure(r)
+		s.mu.Unlock()
+		if failure != nil {
+			for k, v := range failure.Header {
+				w.Header()[k] = v
+			}
+			writeError(w, failure.Status, "injected failure")
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		next.ServeHTTP(w, r)
+	})
+}
+
+// failure returns the failure matching r, using up one of its times. The
+// caller holds s.mu.
+func (s *Server) failure(r *http.Request) *Failure {
+	for i, f := range s.failures {
+		if f.Method != "" && f.Method != r.Method || !strings.HasPrefix(r.URL.Path, f.Path) {
+			continue
+		}
+		if f.Times > 0 {
+			f.Times--
+			if f.Times == 0 {
+				s.failures = append(s.failures[:i:i], s.failures[i+1:]...)
+			}
+		}
+		return f
+	}
+	return nil
+}
+
+func (s *Server) listDomains(w http.ResponseWriter, r *http.Request) {
+	skip, take, ok := page(w, r, MaxDomainsTake)
+	if !ok {
+		return
+	}
+	s.mu.Lock()
+	domains := make([]spaceship.Domain, 0, len(s.domains))
+	for _, d := range s.domains {
+		domains = append(domains, d.info)
+	}
+	s.mu.Unlock()
+
+	orderBy := r.URL.Query().Get("orderBy")
+	desc := strings.HasPrefix(orderBy, "-")
+	var less func(a, b spaceship.Domain) bool
+	switch strings.TrimPrefix(orderBy, "-") {
+	case "", "name", "unicodeName":
+		less = func(a, b spaceship.Domain) bool { return a.Name < b.Name }
+	case "registrationDate":
+		less = func(a, b spaceship.Domain) bool { return a.RegistrationDate.Before(b.RegistrationDate) }
+	case "expirationDate":
+		less = func(a, b spaceship.Domain) bool { return a.ExpirationDate.Before(b.ExpirationDate) }
+	default:
+		writeFieldError(w, "orderBy", "unsupported order "+orderBy)
+		return
+	}
+	sort.SliceStable(domains, func(i, j int) bool {
+		if desc {
+			return less(domains[j], domains[i])
+		}
+		return less(domains[i], domains[j])
+	})
+	writePage(w, domains, skip, take)
+}
+
+func (s *Server) getDomain(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	d, ok := s.domains[strings.ToLower(r.PathValue("domain"))]
+	var info spaceship.Domain
+	if ok {
+		info = d.info
+	}
+	s.mu.Unlock()
+	if !ok {
+		writeError(w, http.StatusNotFound, "domain not found")
+		return
+	}
+	json.NewEncoder(w).Encode(info)
+}
+
+func (s *Server) setNameservers(w http.ResponseWriter, r *http.Request) {
+	var ns spaceship.Nameservers
+	if err := json.NewDecoder(r.Body).Decode(&ns); err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	switch {
+	case ns.Provider == spaceship.NameserversBasic && len(ns.Hosts) > 0:
+		writeFieldError(w, "hosts", "must be empty for basic nameservers")
+		return
+	case ns.Provider == spaceship.NameserversCustom && (len(ns.Hosts) < 2 || len(ns.Hosts) > 12):
+		writeFieldError(w, "hosts", "between 2 and 12 hosts are required")
+		return
+	case ns.Provider != spaceship.NameserversBasic && ns.Provider != spaceship.NameserversCustom:
+		writeFieldError(w, "provider", "unknown provider "+ns.Provider)
+		return
+	}
+	s.mu.Lock()
+	d, ok := s.domains[strings.ToLower(r.PathValue("domain"))]
+	if ok {
+		d.info.Nameservers = ns
+	}
+	s.mu.Unlock()
+	if !ok {
+		writeError(w, http.StatusNotFound, "domain not found")
+		return
+	}
+	json.NewEncoder(w).Encode(ns)
+}
+
+func (s *Server) listRecords(w http.ResponseWriter, r *http.Request) {
+	skip, take, ok := page(w, r, MaxRecordsTake)
+	if !ok {
+		return
+	}
+	s.mu.Lock()
+	d, found := s.domains[strings.ToLower(r.PathValue("domain"))]
+	var records []spaceship.Record
+	if found {
+		records = append(records, d.records...)
+	}
+	s.mu.Unlock()
+	if !found {
+		writeError(w, http.StatusNotFound, "domain not found")
+		return
+	}
+	writePage(w, records, skip, take)
+}
+
+// putRecords creates records. With force, the existing records of every type
+// and name written are replaced; without, writing to an existing set that
+// differs is a conflict.
+func (s *Server) putRecords(w http.ResponseWriter, r *http.Request) {
+	var payload struct {
+		Force bool               `json:"force"`
+		Items []spaceship.Record `json:"items"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	for i, item := range payload.Items {
+		if item.TTL < MinTTL || item.TTL > MaxTTL {
+			writeFieldError(w, fmt.Sprintf("items[%d].ttl", i), fmt.Sprintf("must be between %d and %d", MinTTL, MaxTTL))
+			return
+		}
+		if _, err := json.Marshal(item); err != nil {
+			writeFieldError(w, fmt.Sprintf("items[%d].type", i), err.Error())
+			return
+		}
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	d, ok := s.domains[strings.ToLower(r.PathValue("domain"))]
+	if !ok {
+		writeError(w, http.StatusNotFound, "domain not found")
+		return
+	}
+	written := make(map[string]bool)
+	for _, item := range payload.Items {
+		written[setKey(item)] = true
+	}
+	kept := make([]spaceship.Record, 0, len(d.records))
+	for _, record := range d.records {
+		if !written[setKey(record)] {
+			kept = append(kept, record)
+			continue
+		}
+		if !payload.Force && !containsRecord(payload.Items, record) {
+			writeError(w, http.StatusConflict, fmt.Sprintf("%s record %s already exists", record.Type, record.Name))
+			return
+		}
+	}
+	d.records = append(kept, payload.Items...)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// deleteRecords removes the records matching the given ones in every field
+// but the TTL. Records that do not exist are ignored.
+func (s *Server) deleteRecords(w http.ResponseWriter, r *http.Request) {
+	var items []spaceship.Record
+	if err := json.NewDecoder(r.Body).Decode(&items); err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	d, ok := s.domains[strings.ToLower(r.PathValue("domain"))]
+	if !ok {
+		writeError(w, http.StatusNotFound, "domain not found")
+		return
+	}
+	kept := d.records[:0]
+	for _, record := range d.records {
+		if !containsRecord(items, record) {
+			kept = append(kept, record)
+		}
+	}
+	d.records = kept
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func setKey(r spaceship.Record) string {
+	return strings.ToUpper(r.Type) + " " + strings.ToLower(r.Name) + " " + r.Service + " " + r.Protocol
+}
+
+// containsRecord reports whether records holds r, ignoring TTLs.
+func containsRecord(records []spaceship.Record, r spaceship.Record) bool {
+	r.TTL = 0
+	for _, other := range records {
+		other.TTL = 0
+		if strings.EqualFold(other.Name, r.Name) {
+			other.Name = r.Name
+		}
+		if other == r {
+			return true
+		}
+	}
+	return false
+}
+
+// page parses skip and take, answering 422 when they are out of range.
+func page(w http.ResponseWriter, r *http.Request, maxTake int) (skip, take int, ok bool) {
+	query := r.URL.Query()
+	take, err := strconv.Atoi(query.Get("take"))
+	if err != nil || take < 1 || take > maxTake {
+		writeFieldError(w, "take", fmt.Sprintf("must be between 1 and %d", maxTake))
+		return 0, 0, false
+	}
+	skip, err = strconv.Atoi(query.Get("skip"))
+	if err != nil || skip < 0 {
+		writeFieldError(w, "skip", "must be 0 or more")
+		return 0, 0, false
+	}
+	return skip, take, true
+}
+
+func writePage[T any](w http.ResponseWriter, items []T, skip, take int) {
+	total := len(items)
+	items = items[min(skip, total):min(skip+take, total)]
+	json.NewEncoder(w).Encode(struct {
+		Items []T `json:"items"`
+		Total int `json:"total"`
+	}{items, total})
+}
+
+func writeError(w http.ResponseWriter, status int, detail string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]string{"detail": detail})
+}
+
+func writeFieldError(w http.ResponseWriter, field, detail string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusUnprocessableEntity)
+	json.NewEncoder(w).Encode(map[string]any{
+		"detail": "validation failed",
+		"data":   []map[string]string{{"field": field, "details": detail}},
+	})
+}
@@ -0,0 +1,157 @@
+package spaceshiptest
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/erkki/dnsupdater/internal/provider"
+	"github.com/erkki/dnsupdater/internal/spaceship"
+)
+
+func TestServerChecksCredentials(t *testing.T) {
+	s := NewServer(t)
+	client := spaceship.NewClient(s.URL, "wrong", APISecret, s.Server.Client())
+	_, err := client.ListDomains(context.Background(), spaceship.DomainFilter{})
+	if !spaceship.IsUnauthorized(err) {
+		t.Fatalf("expected an unauthorized error, got %v", err)
+	}
+}
+
+func TestServerChecksCredentialsBeforeFailures(t *testing.T) {
+	s := NewServer(t)
+	s.AddDomain(spaceship.Domain{Name: "example.com"})
+	s.Fail(Failure{Path: "/v1/domains/", Status: http.StatusServiceUnavailable, Times: 1})
+
+	client := spaceship.NewClient(s.URL, "wrong", APISecret, s.Server.Client())
+	if _, err := client.GetDomain(context.Background(), "example.com"); !spaceship.IsUnauthorized(err) {
+		t.Fatalf("expected an unauthorized error, got %v", err)
+	}
+	resp, err := s.Server.Client().Do(authorized(t, s, http.MethodGet, "/v1/domains/example.com", ""))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusServiceUnavailable {
+		t.Fatalf("expected the failure to be left for an authorized request, got %d", resp.StatusCode)
+	}
+}
+
+func TestServerPaginates(t *testing.T) {
+	s := NewServer(t)
+	for i := range 150 {
+		s.AddDomain(spaceship.Domain{Name: fmt.Sprintf("d%03d.com", i)})
+	}
+	domains, err := s.Client().ListDomains(context.Background(), spaceship.DomainFilter{})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(domains) != 150 || domains[0].Name != "d000.com" || domains[149].Name != "d149.com" {
+		t.Fatalf("unexpected domains: %d", len(domains))
+	}
+	if n := len(s.Requests()); n != 2 {
+		t.Fatalf("expected 2 pages, got %d requests", n)
+	}
+
+	resp, err := s.Server.Client().Do(authorized(t, s, http.MethodGet, "/v1/domains?take=101&skip=0", ""))
+	if err != nil {
+		t.Fatalf("request failed: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusUnprocessableEntity {
+		t.Fatalf("expected take above the limit to be rejected, got %d", resp.StatusCode)
+	}
+}
+
+func TestServerRecordSemantics(t *testing.T) {
+	s := NewServer(t)
+	s.AddDomain(spaceship.Domain{Name: "example.com"},
+		spaceship.Record{Type: "A", Name: "www", TTL: 300, Address: "192.0.2.1"},
+		spaceship.Record{Type: "A", Name: "www", TTL: 300, Address: "192.0.2.2"},
+	)
+	client := s.Client()
+	ctx := context.Background()
+
+	// One address of the round-robin set.
+	if err := client.RemoveRecords(ctx, "example.com", spaceship.Record{Type: "A", Name: "www", Address: "192.0.2.1"}); err != nil {
+		t.Fatalf("remove: %v", err)
+	}
+	if got := s.Records("example.com"); len(got) != 1 || got[0].Address != "192.0.2.2" {
+		t.Fatalf("unexpected records after remove: %+v", got)
+	}
+
+	mx := spaceship.Record{Type: "MX", Name: "@", TTL: 3600, Exchange: "mail.example.com", Preference: 10}
+	if err := client.PutRecords(ctx, "example.com", false, mx); err != nil {
+		t.Fatalf("create: %v", err)
+	}
+	other := spaceship.Record{Type: "A", Name: "www", TTL: 300, Address: "192.0.2.3"}
+	if err := client.PutRecords(ctx, "example.com", false, other); !errors.Is(err, provider.ErrConflict) {
+		t.Fatalf("expected a conflict without force, got %v", err)
+	}
+	if err := client.PutRecords(ctx, "example.com", true, other); err != nil {
+		t.Fatalf("forced write: %v", err)
+	}
+	set, err := client.RecordSet(ctx, "example.com", "A", "www")
+	if err != nil || len(set) != 1 || set[0].Address != "192.0.2.3" {
+		t.Fatalf("expected the set to be replaced, got %+v, %v", set, err)
+	}
+	if got := s.Records("example.com"); len(got) != 2 || got[0] != mx {
+		t.Fatalf("unexpected records: %+v", got)
+	}
+
+	if _, err := client.ListRecords(ctx, "missing.com"); !spaceship.IsNotFound(err) {
+		t.Fatalf("expected not found, got %v", err)
+	}
+}
+
+func TestServerValidatesTTL(t *testing.T) {
+	s := NewServer(t)
+	s.AddDomain(spaceship.Domain{Name: "example.com"})
+	body := `{"force":true,"items":[{"type":"A","name":"@","ttl":30,"address":"192.0.2.1"}]}`
+	resp, err := s.Server.Client().Do(authorized(t, s, http.MethodPut, "/v1/dns/records/example.com", body))
+	if err != nil {
+		t.Fatalf("request failed: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusUnprocessableEntity || len(s.Records("example.com")) != 0 {
+		t.Fatalf("expected the TTL to be rejected, got %d", resp.StatusCode)
+	}
+}
+
+func TestServerInjectsFailures(t *testing.T) {
+	s := NewServer(t)
+	s.AddDomain(spaceship.Domain{Name: "example.com"})
+	s.Fail(Failure{Method: http.MethodGet, Path: "/v1/dns/records/", Status: http.StatusServiceUnavailable, Times: 2})
+	client := s.Client()
+
+	// Two failures are within the client's retries.
+	if _, err := client.ListRecords(context.Background(), "example.com"); err != nil {
+		t.Fatalf("expected retries to succeed, got %v", err)
+	}
+	s.Fail(Failure{Path: "/v1/dns/", Status: http.StatusInternalServerError})
+	if _, err := client.ListRecords(context.Background(), "example.com"); err == nil {
+		t.Fatalf("expected a persistent failure")
+	}
+
+	s.SetLatency(time.Second)
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if _, err := client.GetDomain(ctx, "example.com"); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected the latency to exceed the deadline, got %v", err)
+	}
+}
+
+func authorized(t *testing.T, s *Server, method, path, body string) *http.Request {
+	t.Helper()
+	req, err := http.NewRequest(method, s.URL+path, strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("X-Api-Key", APIKey)
+	req.Header.Set("X-Api-Secret", APISecret)
+	return req
+}
@@ -0,0 +1,235 @@
+package updater
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/erkki/dnsupdater/internal/desired"
+	"github.com/erkki/dnsupdater/internal/ipcheck"
+	"github.com/erkki/dnsupdater/internal/provider"
+)
+
+const planVersion = 1
+
+// Plan is the set of changes a sync would make, computed against the live
+// records at CreatedAt. It can be saved and applied later.
+type Plan struct {
+	Version   int                       `json:"version"`
+	CreatedAt time.Time                 `json:"created_at"`
+	Provider  string                    `json:"provider"`
+	IPs       map[ipcheck.Family]string `json:"ips"`
+	Changes   []desired.Change          `json:"changes"`
+}
+
+// Plan detects the public IPs, reloads the live records and returns the
+// changes a sync would make, without writing anything.
+func (u *Updater) Plan(ctx context.Context) (*Plan, error) {
+	ips, err := u.detectIPs(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if err := u.LoadRecords(ctx); err != nil {
+		return nil, err
+	}
+	changes, err := u.changes(ips)
+	if err != nil {
+		return nil, err
+	}
+	plan := &Plan{
+		Version:   planVersion,
+		CreatedAt: time.Now().UTC(),
+		Provider:  u.provider.Name(),
+		IPs:       make(map[ipcheck.Family]string, len(ips)),
+		Changes:   changes,
+	}
+	for family, ip := range ips {
+		plan.IPs[family] = ip.String()
+	}
+	return plan, nil
+}
+
+// Apply executes a plan. The live records are reloaded first and the plan is
+// refused with an error wrapping desired.ErrStale if any set it changes was
+// modified since the plan was made.
+func (u *Updater) Apply(ctx context.Context, plan *Plan) ([]DomainResult, error) {
+	if plan.Provider != u.provider.Name() {
+		return nil, fmt.Errorf("plan was made for provider %s, not %s", plan.Provider, u.provider.Name())
+	}
+	if err := u.LoadRecords(ctx); err != nil {
+		return nil, err
+	}
+	for _, change := range plan.Changes {
+		if !u.readable(change.Key.Domain) {
+			return nil, fmt.Errorf("cannot verify plan: %w", u.unreadable)
+		}
+	}
+	if err := desired.Verify(plan.Changes, u.records); err != nil {
+		return nil, err
+	}
+
+	results := u.applyAll(ctx, plan.Changes)
+	return results, failures(results)
+}
+
+// changes returns the changes a sync would make for ips.
+func (u *Updater) changes(ips map[ipcheck.Family]net.IP) ([]desired.Change, error) {
+	if u.desired != nil {
+		return u.desiredChanges(ips)
+	}
+	var changes []desired.Change
+	for _, fetcher := range u.fetchers {
+		family := fetcher.Family()
+		ip := ips[family]
+		if ip == nil {
+			continue
+		}
+		var lastIP net.IP
+		last, err := u.cache.Load(string(family))
+		if err != nil {
+			return nil, err
+		}
+		if last != nil {
+			lastIP = last.IP
+		}
+		for _, records := range u.selectRecords(family, lastIP) {
+			changes = append(changes, followChanges(records, ip)...)
+		}
+	}
+	sort.Slice(changes, func(i, j int) bool { return changes[i].Key.String() < changes[j].Key.String() })
+	return changes, nil
+}
+
+// followChanges returns an update for every set among records that does not
+// point at ip. As in a sync, each set is written as a single record.
+func followChanges(records []provider.Record, ip net.IP) []desired.Change {
+	sets := make(map[desired.Key][]provider.Record)
+	var keys []desired.Key
+	for _, record := range records {
+		key := desired.KeyOf(record)
+		if _, ok := sets[key]; !ok {
+			keys = append(keys, key)
+		}
+		sets[key] = append(sets[key], record)
+	}
+	var changes []desired.Change
+	for _, key := range keys {
+		set := sets[key]
+		if len(staleRecords(set, ip)) == 0 {
+			continue
+		}
+		changes = append(changes, desired.Change{
+			Action: desired.ActionUpdate,
+			Key:    key,
+			Old:    set,
+			New:    withContent(set[:1], ip),
+		})
+	}
+	return changes
+}
+
+// Summary counts the changes by action, e.g. "1 to create, 2 to update, 0 to delete".
+func (p *Plan) Summary() string {
+	counts := make(map[desired.Action]int)
+	for _, change := range p.Changes {
+		counts[change.Action]++
+	}
+	return fmt.Sprintf("%d to create, %d to update, %d to delete",
+		counts[desired.ActionCreate], counts[desired.ActionUpdate], counts[desired.ActionDelete])
+}
+
+// WriteTable prints the plan as an aligned table, one row per record set.
+func (p *Plan) WriteTable(w io.Writer) error {
+	if len(p.Changes) == 0 {
+		_, err := fmt.Fprintln(w, "No changes. Live records match.")
+		return err
+	}
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "ACTION\tDOMAIN\tNAME\tTYPE\tOLD\tNEW")
+	for _, change := range p.Changes {
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t%s\n", change.Action, change.Key.Domain, change.Key.Name, change.Key.Type,
+			formatSet(change.Old), formatSet(change.New))
+	}
+	if err := tw.Flush(); err != nil {
+		return err
+	}
+	_, err := fmt.Fprintf(w, "\nPlan: %s.\n", p.Summary())
+	return err
+}
+
+// WriteJSON prints the plan in the format read by LoadPlan.
+func (p *Plan) WriteJSON(w io.Writer) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(p)
+}
+
+// Save writes the plan to path as JSON. The plan is written to a temporary
+// file that replaces path once synced, so a failed write leaves any previous
+// plan intact.
+func (p *Plan) Save(path string) error {
+	dir := filepath.Dir(path)
+	tmp, err := os.CreateTemp(dir, filepath.Base(path)+".tmp-*")
+	if err != nil {
+		return err
+	}
+	tmpName := tmp.Name()
+	defer os.Remove(tmpName) // no-op once renamed
+
+	if err := p.WriteJSON(tmp); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmpName, path); err != nil {
+		return err
+	}
+
+	// Persist the rename itself.
+	if d, err := os.Open(dir); err == nil {
+		_ = d.Sync()
+		d.Close()
+	}
+	return nil
+}
+
+// LoadPlan reads a plan saved with Save.
+func LoadPlan(path string) (*Plan, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var plan Plan
+	if err := json.Unmarshal(data, &plan); err != nil {
+		return nil, fmt.Errorf("parse plan %s: %w", path, err)
+	}
+	if plan.Version != planVersion {
+		return nil, fmt.Errorf("plan %s has unsupported version %d", path, plan.Version)
+	}
+	return &plan, nil
+}
+
+func formatSet(records []provider.Record) string {
+	if len(records) == 0 {
+		return "-"
+	}
+	parts := make([]string, len(records))
+	for i, record := range records {
+		parts[i] = fmt.Sprintf("%s (ttl %d)", record.Content, record.TTL)
+	}
+	return strings.Join(parts, ", ")
+}
@@ -0,0 +1,239 @@
+package updater
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"sort"
+	"time"
+
+	"github.com/erkki/dnsupdater/internal/cache"
+	"github.com/erkki/dnsupdater/internal/desired"
+	"github.com/erkki/dnsupdater/internal/ipcheck"
+	"github.com/erkki/dnsupdater/internal/provider"
+)
+
+// reconcile brings the live records in line with the desired state. Unlike
+// the IP-following mode it runs every cycle, since static records may differ
+// even when the IP has not changed.
+func (u *Updater) reconcile(ctx context.Context, result *SyncResult) error {
+	changes, err := u.desiredChanges(result.IPs)
+	if err != nil {
+		return err
+	}
+	if len(changes) == 0 {
+		u.logger.Info("records match desired state", "records", len(u.desired.Records))
+		return u.saveIPs(result.IPs)
+	}
+
+	results := u.applyAll(ctx, changes)
+	result.Domains = append(result.Domains, results...)
+	if err := failures(results); err != nil {
+		return fmt.Errorf("%w, IP not cached", err)
+	}
+	return u.saveIPs(result.IPs)
+}
+
+// applyAll applies changes domain by domain and returns the result of each
+// domain.
+func (u *Updater) applyAll(ctx context.Context, changes []desired.Change) []DomainResult {
+	byDomain := make(map[string][]desired.Change)
+	var domains []string
+	for _, change := range changes {
+		if _, ok := byDomain[change.Key.Domain]; !ok {
+			domains = append(domains, change.Key.Domain)
+		}
+		byDomain[change.Key.Domain] = append(byDomain[change.Key.Domain], change)
+	}
+	sort.Strings(domains)
+
+	var results []DomainResult
+	for _, domain := range domains {
+		res := u.applyChanges(ctx, domain, byDomain[domain])
+		results = append(results, res)
+		if abort(res.Err) {
+			u.logger.Error("provider rejected the credentials, not updating further domains", "err", res.Err)
+			break
+		}
+	}
+	return results
+}
+
+// desiredChanges diffs the desired state, resolved for ips, against the
+// loaded records.
+func (u *Updater) desiredChanges(ips map[ipcheck.Family]net.IP) ([]desired.Change, error) {
+	want, pending, err := u.desired.Resolve(ips)
+	if err != nil {
+		return nil, err
+	}
+	for key := range pending {
+		u.logger.Warn("leaving record untouched, its address is unknown", "domain", key.Domain, "name", key.Name, "type", key.Type)
+	}
+	var prune func(provider.Record) bool
+	if u.desired.Prune {
+		prune = u.prunable
+	}
+	changes := desired.Diff(want, u.records, pending, prune)
+	kept := changes[:0]
+	for _, change := range changes {
+		if !u.readable(change.Key.Domain) {
+			u.logger.Warn("leaving record untouched, its domain could not be read", "domain", change.Key.Domain, "name", change.Key.Name, "type", change.Key.Type)
+			continue
+		}
+		kept = append(kept, change)
+	}
+	return kept, nil
+}
+
+// prunable reports whether an undeclared live record may be deleted.
+func (u *Updater) prunable(record provider.Record) bool {
+	key := desired.KeyOf(record)
+	declared := false
+	for _, domain := range u.desired.Domains() {
+		if domain == key.Domain {
+			declared = true
+			break
+		}
+	}
+	if !declared || !u.provider.Capabilities().Supports(key.Type) {
+		return false
+	}
+	// Never remove the delegation of a zone.
+	if key.Name == "@" && (key.Type == "NS" || key.Type == "SOA") {
+		return false
+	}
+	if ok, reason := u.selector.Select(record, nil); !ok {
+		u.logger.Info("not pruning record", "domain", record.Domain, "name", record.Name, "type", record.Type, "reason", reason)
+		return false
+	}
+	return true
+}
+
+// applyChanges applies the changes of one domain as a transaction: if one
+// fails, the changes already applied are undone in reverse order.
+func (u *Updater) applyChanges(ctx context.Context, domain string, changes []desired.Change) DomainResult {
+	result := DomainResult{Domain: domain, Records: len(changes)}
+
+	var applied []desired.Change
+	for _, change := range changes {
+		if err := u.applyChange(ctx, change); err != nil {
+			result.Err = err
+			// The failed change may have been applied in part.
+			applied = append(applied, change)
+			break
+		}
+		applied = append(applied, change)
+		if !u.dryRun {
+			u.markChanged(change)
+		}
+	}
+	if result.Err == nil || !u.classify(&result) {
+		return result
+	}
+
+	u.logger.Warn("rolling back domain", "domain", domain, "count", len(applied), "err", result.Err)
+	rollbackCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), rollbackTimeout)
+	defer cancel()
+	var errs []error
+	for i := len(applied) - 1; i >= 0; i-- {
+		inverse := applied[i].Inverse()
+		if err := u.applyChange(rollbackCtx, inverse); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", inverse.Key, err))
+			continue
+		}
+		if i < len(applied)-1 {
+			u.markChanged(inverse)
+		}
+	}
+	if err := errors.Join(errs...); err != nil {
+		result.RollbackErr = err
+		u.logger.Error("rollback failed, domain may be missing records", "domain", domain, "err", err)
+		return result
+	}
+	result.RolledBack = true
+	u.logger.Info("rolled back domain", "domain", domain, "count", len(applied))
+	return result
+}
+
+// applyChange writes one record set change to the provider.
+func (u *Updater) applyChange(ctx context.Context, change desired.Change) error {
+	key := change.Key
+	if u.dryRun {
+		u.logger.Info("dry-run: would "+string(change.Action)+" records", "domain", key.Domain, "name", key.Name, "type", key.Type,
+			"old", contents(change.Old), "new", contents(change.New))
+		return nil
+	}
+	u.logger.Info("applying change", "action", change.Action, "domain", key.Domain, "name", key.Name, "type", key.Type,
+		"old", contents(change.Old), "new", contents(change.New))
+
+	switch change.Action {
+	case desired.ActionCreate:
+		return u.provider.UpsertRecords(ctx, key.Domain, change.New)
+	case desired.ActionDelete:
+		return u.provider.DeleteRecords(ctx, key.Domain, change.Old)
+	}
+	if u.provider.Capabilities().InPlaceUpdate {
+		err := u.provider.UpsertRecords(ctx, key.Domain, change.New)
+		if !errors.Is(err, provider.ErrConflict) {
+			return err
+		}
+		u.logger.Warn("in-place update rejected, falling back to delete and create", "domain", key.Domain, "name", key.Name, "err", err)
+	}
+	if err := u.provider.DeleteRecords(ctx, key.Domain, change.Old); err != nil {
+		return err
+	}
+	return u.provider.UpsertRecords(ctx, key.Domain, change.New)
+}
+
+// markChanged replaces the loaded records of the changed set with its new
+// content.
+func (u *Updater) markChanged(change desired.Change) {
+	kept := u.records[:0]
+	for _, record := range u.records {
+		if desired.KeyOf(record) != change.Key {
+			kept = append(kept, record)
+		}
+	}
+	u.records = append(kept, change.New...)
+}
+
+// saveIPs caches the detected IPs that differ from the cached ones. Nothing
+// is cached in dry-run, since no record was written.
+func (u *Updater) saveIPs(ips map[ipcheck.Family]net.IP) error {
+	if u.dryRun {
+		for family, ip := range ips {
+			u.logger.Info("dry-run: IP not cached", "family", family, "ip", ip.String())
+		}
+		return nil
+	}
+	for family, ip := range ips {
+		last, err := u.cache.Load(string(family))
+		if err != nil {
+			return err
+		}
+		if last != nil && ip.Equal(last.IP) {
+			continue
+		}
+		var keys []string
+		for _, spec := range u.desired.Records {
+			if f, ok := spec.Family(); ok && f == family {
+				keys = append(keys, spec.Key().String())
+			}
+		}
+		sort.Strings(keys)
+		if err := u.cache.Save(string(family), cache.Entry{IP: ip, UpdatedAt: time.Now(), Records: keys}); err != nil {
+			return err
+		}
+		u.logger.Info("IP updated", "family", family, "ip", ip.String())
+	}
+	return nil
+}
+
+func contents(records []provider.Record) []string {
+	res := make([]string, len(records))
+	for i, record := range records {
+		res[i] = fmt.Sprintf("%s ttl=%d", record.Content, record.TTL)
+	}
+	return res
+}
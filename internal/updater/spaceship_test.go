@@ -0,0 +1,172 @@
+package updater
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/erkki/dnsupdater/internal/cache"
+	"github.com/erkki/dnsupdater/internal/desired"
+	"github.com/erkki/dnsupdater/internal/ipcheck"
+	"github.com/erkki/dnsupdater/internal/spaceship"
+	"github.com/erkki/dnsupdater/internal/spaceship/spaceshiptest"
+)
+
+// newFakeUpdater returns an updater syncing ip to the fake Spaceship API.
+func newFakeUpdater(t *testing.T, s *spaceshiptest.Server, ip string, opts Options) *Updater {
+	t.Helper()
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	fetcher := ipcheck.NewFetcher(ipcheck.IPv4, nil, nil, net.ParseIP(ip))
+	opts.PollInterval = time.Hour
+	u := New(logger, []*ipcheck.Fetcher{fetcher}, cache.NewMemoryCache(), s.Client(), opts)
+	if err := u.LoadRecords(context.Background()); err != nil {
+		t.Fatalf("load records: %v", err)
+	}
+	return u
+}
+
+func TestSpaceshipFollowsIP(t *testing.T) {
+	s := spaceshiptest.NewServer(t)
+	s.AddDomain(spaceship.Domain{Name: "example.com"},
+		spaceship.Record{Type: "A", Name: "@", TTL: 300, Address: "198.51.100.1"},
+		spaceship.Record{Type: "A", Name: "home", TTL: 600, Address: "198.51.100.1"},
+		spaceship.Record{Type: "MX", Name: "@", TTL: 3600, Exchange: "mail.example.com", Preference: 10},
+	)
+	s.AddDomain(spaceship.Domain{Name: "other.org"},
+		spaceship.Record{Type: "A", Name: "@", TTL: 300, Address: "203.0.113.7"},
+	)
+	// The first write fails transiently and is retried by the client.
+	s.Fail(spaceshiptest.Failure{Method: http.MethodPut, Status: http.StatusServiceUnavailable, Times: 1})
+	u := newFakeUpdater(t, s, "203.0.113.7", Options{Strategy: StrategyUpsert})
+
+	if _, err := u.Sync(context.Background()); err != nil {
+		t.Fatalf("sync failed: %v", err)
+	}
+	records := s.Records("example.com")
+	if len(records) != 3 {
+		t.Fatalf("unexpected records: %+v", records)
+	}
+	for _, record := range records {
+		if record.Type == "A" && record.Address != "203.0.113.7" {
+			t.Fatalf("record %s not updated: %+v", record.Name, record)
+		}
+		if record.Name == "home" && record.TTL != 600 {
+			t.Fatalf("TTL not preserved: %+v", record)
+		}
+	}
+	if writes := s.Writes(); len(writes) != 2 || writes[0].Path != "/v1/dns/records/example.com" {
+		t.Fatalf("expected one retried write to example.com, got %+v", writes)
+	}
+
+	// Nothing is written once the records match, even after a reload.
+	if err := u.LoadRecords(context.Background()); err != nil {
+		t.Fatalf("reload: %v", err)
+	}
+	if _, err := u.Sync(context.Background()); err != nil {
+		t.Fatalf("second sync failed: %v", err)
+	}
+	if writes := s.Writes(); len(writes) != 2 {
+		t.Fatalf("expected no further writes, got %+v", writes)
+	}
+}
+
+func TestSpaceshipReconcilesTypedRecords(t *testing.T) {
+	s := spaceshiptest.NewServer(t)
+	s.AddDomain(spaceship.Domain{Name: "example.com"},
+		spaceship.Record{Type: "TXT", Name: "@", TTL: 300, Value: "old"},
+		spaceship.Record{Type: "CNAME", Name: "legacy", TTL: 300, CName: "example.com"},
+	)
+	state, err := desired.Parse([]byte(`
+ttl: 300
+prune: true
+records:
+  - domain: example.com
+    name: "@"
+    type: A
+  - domain: example.com
+    name: "@"
+    type: MX
+    value: 10 mail.example.com
+  - domain: example.com
+    name: _sip._tcp
+    type: SRV
+    value: 10 5 5060 sip.example.com
+  - domain: example.com
+    name: "@"
+    type: CAA
+    value: 0 issue "letsencrypt.org"
+  - domain: example.com
+    name: "@"
+    type: TXT
+    source: template
+    value: "home={{.IPv4}}"
+`))
+	if err != nil {
+		t.Fatalf("parse state: %v", err)
+	}
+	u := newFakeUpdater(t, s, "203.0.113.7", Options{Desired: state})
+
+	if _, err := u.Sync(context.Background()); err != nil {
+		t.Fatalf("sync failed: %v", err)
+	}
+	byType := make(map[string]spaceship.Record)
+	for _, record := range s.Records("example.com") {
+		byType[record.Type] = record
+	}
+	if len(byType) != 5 || byType["CNAME"].Type != "" {
+		t.Fatalf("unexpected records: %+v", s.Records("example.com"))
+	}
+	srv := byType["SRV"]
+	if srv.Service != "_sip" || srv.Protocol != "_tcp" || srv.Name != "@" || srv.Port != 5060 || srv.Weight != 5 {
+		t.Fatalf("unexpected SRV record: %+v", srv)
+	}
+	if byType["CAA"].Value != "letsencrypt.org" || byType["TXT"].Value != "home=203.0.113.7" || byType["MX"].Preference != 10 {
+		t.Fatalf("unexpected records: %+v", byType)
+	}
+
+	// Read back from the API, the records match the desired state.
+	writes := len(s.Writes())
+	if err := u.LoadRecords(context.Background()); err != nil {
+		t.Fatalf("reload: %v", err)
+	}
+	if _, err := u.Sync(context.Background()); err != nil {
+		t.Fatalf("second sync failed: %v", err)
+	}
+	if got := s.Writes(); len(got) != writes {
+		t.Fatalf("expected no further writes, got %+v", got[writes:])
+	}
+}
+
+func TestSpaceshipCollapsesRoundRobinSet(t *testing.T) {
+	s := spaceshiptest.NewServer(t)
+	s.AddDomain(spaceship.Domain{Name: "example.com"},
+		spaceship.Record{Type: "A", Name: "@", TTL: 300, Address: "198.51.100.1"},
+		spaceship.Record{Type: "A", Name: "@", TTL: 300, Address: "198.51.100.2"},
+	)
+	var logs strings.Builder
+	logger := slog.New(slog.NewTextHandler(&logs, nil))
+	fetcher := ipcheck.NewFetcher(ipcheck.IPv4, nil, nil, net.ParseIP("203.0.113.7"))
+	u := New(logger, []*ipcheck.Fetcher{fetcher}, cache.NewMemoryCache(), s.Client(), Options{PollInterval: time.Hour, Strategy: StrategyUpsert})
+	if err := u.LoadRecords(context.Background()); err != nil {
+		t.Fatalf("load records: %v", err)
+	}
+	if _, err := u.Sync(context.Background()); err != nil {
+		t.Fatalf("sync failed: %v", err)
+	}
+	if records := s.Records("example.com"); len(records) != 1 || records[0].Address != "203.0.113.7" {
+		t.Fatalf("expected the set to be overwritten by one record, got %+v", records)
+	}
+
+	logs.Reset()
+	if err := u.LoadRecords(context.Background()); err != nil {
+		t.Fatalf("reload: %v", err)
+	}
+	if strings.Contains(logs.String(), "outside the updater") {
+		t.Fatalf("the updater's own write was reported as an outside change:\n%s", logs.String())
+	}
+}
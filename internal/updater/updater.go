@@ -2,163 +2,655 @@ package updater
 
 import (
 	"context"
+	"errors"
+	"fmt"
 	"log/slog"
 	"net"
+	"sort"
 	"time"
 
 	"github.com/erkki/dnsupdater/internal/cache"
+	"github.com/erkki/dnsupdater/internal/desired"
 	"github.com/erkki/dnsupdater/internal/ipcheck"
-	"github.com/erkki/dnsupdater/internal/spaceship"
+	"github.com/erkki/dnsupdater/internal/provider"
+	"github.com/erkki/dnsupdater/internal/rules"
 )
 
+const rollbackTimeout = 30 * time.Second
+
+// Strategy selects how changed records are written to the DNS provider.
+type Strategy string
+
+const (
+	// StrategyUpsert rewrites only the records whose content differs, in place.
+	StrategyUpsert Strategy = "upsert"
+	// StrategyReplace deletes every record of the family in a domain and recreates them.
+	StrategyReplace Strategy = "replace"
+)
+
+// DomainResult describes the outcome of updating the records of one family in one domain.
+type DomainResult struct {
+	Domain  string
+	Family  ipcheck.Family
+	Records int
+	// Err is set when the update failed.
+	Err error
+	// RolledBack reports that the original records were restored after Err.
+	RolledBack bool
+	// RollbackErr is set when restoring the original records failed as well.
+	RollbackErr error
+	// Skipped reports that the domain no longer exists at the provider. Its
+	// records are dropped and Err does not count as a failure.
+	Skipped bool
+}
+
+// failed reports whether the domain needs another attempt.
+func (r DomainResult) failed() bool {
+	return r.Err != nil && !r.Skipped
+}
+
+// classify decides how a failed domain write is handled. Missing domains are
+// skipped and no rollback is attempted; every other error is rolled back.
+// It reports whether a rollback is needed.
+func (u *Updater) classify(result *DomainResult) bool {
+	if errors.Is(result.Err, provider.ErrNotFound) {
+		result.Skipped = true
+		u.dropDomain(result.Domain)
+		u.logger.Warn("domain no longer exists at the provider, skipping it", "domain", result.Domain, "err", result.Err)
+		return false
+	}
+	return true
+}
+
+// failures summarizes the failed domains in one error wrapping each of
+// their errors, or returns nil if none failed.
+func failures(results []DomainResult) error {
+	var errs []error
+	for _, res := range results {
+		if res.failed() {
+			errs = append(errs, fmt.Errorf("%s: %w", res.Domain, res.Err))
+		}
+	}
+	if len(errs) == 0 {
+		return nil
+	}
+	return fmt.Errorf("%d of %d domains failed: %w", len(errs), len(results), errors.Join(errs...))
+}
+
+// abort reports whether err makes updating further domains pointless.
+func abort(err error) bool {
+	return errors.Is(err, provider.ErrUnauthorized)
+}
+
+// dropDomain forgets the loaded records of a domain.
+func (u *Updater) dropDomain(domain string) {
+	kept := u.records[:0]
+	for _, record := range u.records {
+		if record.Domain != domain {
+			kept = append(kept, record)
+		}
+	}
+	u.records = kept
+}
+
+// SyncResult summarizes one sync cycle.
+type SyncResult struct {
+	// IPs holds the detected public address per family.
+	IPs     map[ipcheck.Family]net.IP
+	Domains []DomainResult
+}
+
+// Options tune how an Updater polls and writes records.
+type Options struct {
+	PollInterval time.Duration
+	DryRun       bool
+	Strategy     Strategy
+	// Selector limits which records may be changed. Nil selects every
+	// record of a managed type. With a desired state it limits pruning.
+	Selector *rules.Selector
+	// Desired switches the updater from following every A/AAAA record to
+	// reconciling the declared records.
+	Desired *desired.State
+	// RefreshInterval is how often the live records are re-read and checked
+	// for drift. Zero re-reads them before every poll.
+	RefreshInterval time.Duration
+	// RetryInterval is the wait before retrying a failed sync. It doubles
+	// after every consecutive failure, up to the poll interval. Zero waits
+	// for the next poll.
+	RetryInterval time.Duration
+}
+
 // Updater orchestrates IP detection and DNS updates.
 type Updater struct {
 	logger    *slog.Logger
-	fetcher   *ipcheck.Fetcher
-	cache     *cache.MemoryCache
-	client    *spaceship.Client
+	fetchers  []*ipcheck.Fetcher
+	cache     cache.Store
+	provider  provider.DNSProvider
 	pollEvery time.Duration
 	dryRun    bool
+	strategy  Strategy
+	selector  *rules.Selector
+	desired   *desired.State
+	refresh   time.Duration
+	retry     time.Duration
 
-	records []spaceship.DNSRecord
+	records []provider.Record
+	loaded  bool
+	// unreadable holds the domains whose records could not be read on the
+	// last load. They are left alone until they can be read again.
+	unreadable *provider.PartialError
 }
 
-func New(logger *slog.Logger, fetcher *ipcheck.Fetcher, cache *cache.MemoryCache, client *spaceship.Client, pollEvery time.Duration, dryRun bool) *Updater {
+// New creates an Updater. Each fetcher detects one address family; records of
+// the matching type (A for IPv4, AAAA for IPv6) are kept in sync with it.
+// Providers that cannot update records in place always use StrategyReplace.
+func New(logger *slog.Logger, fetchers []*ipcheck.Fetcher, store cache.Store, dnsProvider provider.DNSProvider, opts Options) *Updater {
+	strategy := opts.Strategy
+	if !dnsProvider.Capabilities().InPlaceUpdate && strategy != StrategyReplace {
+		logger.Info("provider cannot update records in place, using replace strategy", "provider", dnsProvider.Name())
+		strategy = StrategyReplace
+	}
 	return &Updater{
 		logger:    logger,
-		fetcher:   fetcher,
-		cache:     cache,
-		client:    client,
-		pollEvery: pollEvery,
-		dryRun:    dryRun,
+		fetchers:  fetchers,
+		cache:     store,
+		provider:  dnsProvider,
+		pollEvery: opts.PollInterval,
+		dryRun:    opts.DryRun,
+		strategy:  strategy,
+		selector:  opts.Selector,
+		desired:   opts.Desired,
+		refresh:   opts.RefreshInterval,
+		retry:     opts.RetryInterval,
 	}
 }
 
+// LoadRecords reads the live records from the provider. On reloads, records
+// that were added, removed or changed since the last read without going
+// through the updater are logged. When only some domains can be read, the
+// others are loaded and the failed ones are skipped by syncs until a later
+// load succeeds.
 func (u *Updater) LoadRecords(ctx context.Context) error {
-	recs, err := u.client.FetchRecords(ctx)
-	if err != nil {
+	recs, err := u.provider.FetchRecords(ctx)
+	var partial *provider.PartialError
+	if err != nil && !errors.As(err, &partial) {
 		return err
 	}
+	if partial != nil {
+		for _, domain := range partial.Domains() {
+			u.logger.Warn("failed to read records, skipping domain", "domain", domain, "err", partial.Errs[domain])
+		}
+		// Keep what is known of the failed domains so their records are not
+		// reported as removed.
+		for _, record := range u.records {
+			if _, failed := partial.Errs[record.Domain]; failed {
+				recs = append(recs, record)
+			}
+		}
+	}
+	if u.loaded {
+		u.logExternalChanges(recs)
+	}
 	u.records = recs
-	u.logger.Info("loaded records", "count", len(recs))
+	u.loaded = true
+	u.unreadable = partial
+	u.logger.Info("loaded records", "provider", u.provider.Name(), "count", len(recs))
 	return nil
 }
 
+// readable reports whether the records of domain were read on the last load.
+func (u *Updater) readable(domain string) bool {
+	if u.unreadable == nil {
+		return true
+	}
+	_, failed := u.unreadable.Errs[domain]
+	return !failed
+}
+
+// logExternalChanges logs how the live records differ from the loaded ones.
+// The loaded records track every write the updater makes, so any difference
+// was made by someone else, e.g. in the provider's dashboard.
+func (u *Updater) logExternalChanges(live []provider.Record) {
+	for _, change := range desired.Diff(live, u.records, nil, func(provider.Record) bool { return true }) {
+		key := change.Key
+		switch change.Action {
+		case desired.ActionCreate:
+			u.logger.Warn("record added outside the updater", "domain", key.Domain, "name", key.Name, "type", key.Type, "content", contents(change.New))
+		case desired.ActionDelete:
+			u.logger.Warn("record removed outside the updater", "domain", key.Domain, "name", key.Name, "type", key.Type, "content", contents(change.Old))
+		default:
+			u.logger.Warn("record changed outside the updater", "domain", key.Domain, "name", key.Name, "type", key.Type, "old", contents(change.Old), "new", contents(change.New))
+		}
+	}
+}
+
+// Run syncs once and then on every poll until ctx is cancelled. The live
+// records are re-read before each poll, or on their own schedule when a
+// refresh interval is set, so edits made elsewhere are detected and fixed.
 func (u *Updater) Run(ctx context.Context) error {
-	if len(u.records) == 0 {
+	if !u.loaded {
 		if err := u.LoadRecords(ctx); err != nil {
 			return err
 		}
 	}
 
-	if err := u.sync(ctx); err != nil {
-		u.logger.Error("initial sync failed", "err", err)
+	// A failed sync is retried sooner than the next poll, backing off
+	// while it keeps failing.
+	retryDelay := u.retry
+	retryTimer := time.NewTimer(time.Hour)
+	stopTimer(retryTimer)
+	defer retryTimer.Stop()
+	sync := func() {
+		_, err := u.Sync(ctx)
+		stopTimer(retryTimer)
+		if err == nil {
+			retryDelay = u.retry
+			return
+		}
+		if u.retry <= 0 || retryDelay >= u.pollEvery || abort(err) {
+			u.logger.Error("sync failed", "err", err)
+			return
+		}
+		u.logger.Error("sync failed, retrying", "err", err, "retry_in", retryDelay.String())
+		retryTimer.Reset(retryDelay)
+		retryDelay = min(retryDelay*2, u.pollEvery)
 	}
 
+	sync()
+
 	ticker := time.NewTicker(u.pollEvery)
 	defer ticker.Stop()
+	var refreshC <-chan time.Time
+	if u.refresh > 0 {
+		refreshTicker := time.NewTicker(u.refresh)
+		defer refreshTicker.Stop()
+		refreshC = refreshTicker.C
+	}
 
 	for {
 		select {
 		case <-ctx.Done():
 			return ctx.Err()
 		case <-ticker.C:
-			if err := u.sync(ctx); err != nil {
-				u.logger.Error("sync failed", "err", err)
+			if u.refresh == 0 {
+				u.refreshRecords(ctx)
 			}
+		case <-refreshC:
+			u.refreshRecords(ctx)
+		case <-retryTimer.C:
+			u.refreshRecords(ctx)
+		}
+		sync()
+	}
+}
+
+// stopTimer stops t and drains a pending tick so it can be Reset safely.
+func stopTimer(t *time.Timer) {
+	if !t.Stop() {
+		select {
+		case <-t.C:
+		default:
 		}
 	}
 }
 
-func (u *Updater) sync(ctx context.Context) error {
-	currentIP, err := u.fetcher.CurrentIP(ctx)
+// refreshRecords reloads the live records, keeping the previous ones if the
+// provider cannot be reached.
+func (u *Updater) refreshRecords(ctx context.Context) {
+	if err := u.LoadRecords(ctx); err != nil {
+		u.logger.Warn("failed to refresh records, using previous state", "err", err)
+	}
+}
+
+// Sync detects the public IP of every configured family and updates the
+// records that do not match it. The returned result describes every domain
+// that was touched, including rollbacks, even when an error is returned.
+func (u *Updater) Sync(ctx context.Context) (SyncResult, error) {
+	ips, err := u.detectIPs(ctx)
+	result := SyncResult{IPs: ips}
 	if err != nil {
-		return err
+		return result, err
 	}
-	lastIP, err := u.cache.Load()
+
+	var errs []error
+	if u.unreadable != nil {
+		errs = append(errs, u.unreadable)
+	}
+	if u.desired != nil {
+		if err := u.reconcile(ctx, &result); err != nil {
+			errs = append(errs, err)
+		}
+		return result, errors.Join(errs...)
+	}
+	for _, fetcher := range u.fetchers {
+		family := fetcher.Family()
+		if ips[family] == nil {
+			continue
+		}
+		if err := u.syncFamily(ctx, family, ips[family], &result); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", family, err))
+		}
+	}
+	return result, errors.Join(errs...)
+}
+
+// detectIPs returns the public IP of every family that could be detected. It
+// fails without detecting the other families when endpoints disagree.
+func (u *Updater) detectIPs(ctx context.Context) (map[ipcheck.Family]net.IP, error) {
+	ips := make(map[ipcheck.Family]net.IP)
+	for _, fetcher := range u.fetchers {
+		family := fetcher.Family()
+		currentIP, err := fetcher.CurrentIP(ctx)
+		if errors.Is(err, ipcheck.ErrNoConsensus) {
+			// The address may be forged; changing nothing is safer than
+			// updating the other family alone.
+			u.logger.Error("public IP endpoints disagree, not changing any record", "family", family, "err", err)
+			return nil, fmt.Errorf("%s: %w", family, err)
+		}
+		if err != nil {
+			// A missing family must not block updates for the other one.
+			u.logger.Warn("failed to detect public IP", "family", family, "err", err)
+			continue
+		}
+		ips[family] = currentIP
+	}
+	if len(ips) == 0 {
+		return ips, fmt.Errorf("no public IP detected for any address family")
+	}
+	return ips, nil
+}
+
+func (u *Updater) syncFamily(ctx context.Context, family ipcheck.Family, currentIP net.IP, result *SyncResult) error {
+	last, err := u.cache.Load(string(family))
 	if err != nil {
 		return err
 	}
-	if lastIP != nil && currentIP.Equal(lastIP) {
-		u.logger.Info("IP unchanged", "ip", currentIP.String())
-		return nil
+	var lastIP net.IP
+	if last != nil {
+		lastIP = last.IP
 	}
+	changed := !currentIP.Equal(lastIP)
 
-	if err := u.updateRecords(ctx, currentIP); err != nil {
-		return err
+	recordsByDomain := u.selectRecords(family, lastIP)
+	if !changed {
+		// The IP is the one applied before, but records may have been
+		// edited or added since.
+		drifted := 0
+		for _, records := range recordsByDomain {
+			for _, record := range staleRecords(records, currentIP) {
+				u.logger.Warn("record drifted from current IP", "domain", record.Domain, "name", record.Name, "type", record.Type, "content", record.Content, "ip", currentIP.String())
+				drifted++
+			}
+		}
+		if drifted == 0 {
+			u.logger.Info("IP unchanged", "family", family, "ip", currentIP.String())
+			return nil
+		}
+	}
+
+	domains := u.updateRecords(ctx, family, currentIP, recordsByDomain)
+	result.Domains = append(result.Domains, domains...)
+
+	if err := failures(domains); err != nil {
+		// Keep the old IP cached so the next cycle retries the failed domains.
+		return fmt.Errorf("%w, IP not cached", err)
+	}
+	if !changed {
+		u.logger.Info("fixed drifted records", "family", family, "ip", currentIP.String())
+		return nil
+	}
+	if u.dryRun {
+		// Nothing was written, so the next real run must still apply the IP.
+		u.logger.Info("dry-run: IP not cached", "family", family, "ip", currentIP.String())
+		return nil
 	}
 
-	if err := u.cache.Save(currentIP); err != nil {
+	entry := cache.Entry{IP: currentIP, UpdatedAt: time.Now(), Records: u.recordKeys(family)}
+	if err := u.cache.Save(string(family), entry); err != nil {
 		return err
 	}
-	u.logger.Info("IP updated", "ip", currentIP.String())
+	u.logger.Info("IP updated", "family", family, "ip", currentIP.String())
 	return nil
 }
 
-func (u *Updater) updateRecords(ctx context.Context, ip net.IP) error {
-	u.logger.Info("starting record update", "ip", ip.String(), "record_count", len(u.records))
+// updateRecords points the selected records, grouped by domain, at ip.
+func (u *Updater) updateRecords(ctx context.Context, family ipcheck.Family, ip net.IP, recordsByDomain map[string][]provider.Record) []DomainResult {
+	u.logger.Info("starting record update", "family", family, "ip", ip.String(), "record_count", len(u.records))
+
+	// Process each domain
+	var results []DomainResult
+	for domain, domainRecords := range recordsByDomain {
+		stale := staleRecords(domainRecords, ip)
+		if len(stale) == 0 {
+			u.logger.Info("skipping domain - all records already match IP", "domain", domain, "ip", ip.String())
+			continue
+		}
+		res := u.applyDomain(ctx, family, domain, domainRecords, stale, ip)
+		results = append(results, res)
+		if abort(res.Err) {
+			u.logger.Error("provider rejected the credentials, not updating further domains", "err", res.Err)
+			break
+		}
+	}
+	return results
+}
 
-	// Group records by domain
-	recordsByDomain := make(map[string][]spaceship.DNSRecord)
+// selectRecords returns the records of the family's type that the selection
+// rules allow changing, grouped by domain. Records are written per name, so a
+// selected record sharing its name with an excluded one is left alone as well.
+func (u *Updater) selectRecords(family ipcheck.Family, lastIP net.IP) map[string][]provider.Record {
+	recordType := family.RecordType()
+	var selected []provider.Record
+	excluded := make(map[string]bool)
 	for _, record := range u.records {
-		if record.Type != "A" {
-			u.logger.Info("skipping non-A record", "domain", record.Domain, "name", record.Name, "type", record.Type)
+		if !u.readable(record.Domain) {
+			continue
+		}
+		if record.Type != recordType {
+			u.logger.Debug("skipping record of other type", "domain", record.Domain, "name", record.Name, "type", record.Type, "family", family)
+			continue
+		}
+		if ok, reason := u.selector.Select(record, lastIP); !ok {
+			u.logger.Info("skipping record", "domain", record.Domain, "name", record.Name, "type", record.Type, "content", record.Content, "reason", reason)
+			excluded[record.Domain+" "+record.Name] = true
+			continue
+		}
+		selected = append(selected, record)
+	}
+
+	recordsByDomain := make(map[string][]provider.Record)
+	for _, record := range selected {
+		if excluded[record.Domain+" "+record.Name] {
+			u.logger.Info("skipping record", "domain", record.Domain, "name", record.Name, "type", record.Type, "content", record.Content, "reason", "shares its name with a skipped record")
 			continue
 		}
 		recordsByDomain[record.Domain] = append(recordsByDomain[record.Domain], record)
 	}
+	return recordsByDomain
+}
 
-	// Process each domain
-	for domain, domainRecords := range recordsByDomain {
-		// Check if any records need updating
-		needsUpdate := false
-		for _, record := range domainRecords {
-			if record.Content != ip.String() {
-				needsUpdate = true
-				break
-			}
+// applyDomain writes the stale records of one domain as a transaction. The
+// snapshot holds the records as last read from the provider; if any write
+// fails, the touched records are restored from it.
+func (u *Updater) applyDomain(ctx context.Context, family ipcheck.Family, domain string, snapshot, stale []provider.Record, ip net.IP) DomainResult {
+	result := DomainResult{Domain: domain, Family: family, Records: len(stale)}
+
+	touched := stale
+	var err error
+	switch u.strategy {
+	case StrategyReplace:
+		touched = snapshot
+		err = u.replaceRecords(ctx, domain, snapshot, ip)
+	default:
+		err = u.upsertRecords(ctx, domain, stale, ip)
+	}
+	if err == nil {
+		if !u.dryRun {
+			u.markUpdated(domain, touched, ip)
 		}
+		return result
+	}
+	result.Err = err
+	if !u.classify(&result) {
+		return result
+	}
 
-		if !needsUpdate {
-			u.logger.Info("skipping domain - all records already match IP", "domain", domain, "ip", ip.String())
-			continue
+	original := restoreSet(snapshot, touched)
+	u.logger.Warn("rolling back domain", "domain", domain, "family", family, "count", len(original), "err", err)
+
+	// Restore even if the sync was cancelled mid-write.
+	rollbackCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), rollbackTimeout)
+	defer cancel()
+	if rbErr := u.provider.UpsertRecords(rollbackCtx, domain, original); rbErr != nil {
+		result.RollbackErr = rbErr
+		u.logger.Error("rollback failed, domain may be missing records", "domain", domain, "family", family, "err", rbErr)
+		return result
+	}
+	result.RolledBack = true
+	u.logger.Info("rolled back domain", "domain", domain, "family", family, "count", len(original))
+	return result
+}
+
+// upsertRecords rewrites only the stale records in place. Records that already
+// point at ip are never touched. If the API refuses the in-place write because
+// of conflicting records, the stale records are deleted and recreated instead.
+func (u *Updater) upsertRecords(ctx context.Context, domain string, stale []provider.Record, ip net.IP) error {
+	if u.dryRun {
+		u.logger.Info("dry-run: would update records for domain", "domain", domain, "count", len(stale))
+		return nil
+	}
+
+	u.logger.Info("updating records for domain", "domain", domain, "count", len(stale))
+	err := u.provider.UpsertRecords(ctx, domain, withContent(stale, ip))
+	if errors.Is(err, provider.ErrConflict) {
+		u.logger.Warn("in-place update rejected, falling back to delete and create", "domain", domain, "err", err)
+		return u.replaceRecords(ctx, domain, stale, ip)
+	}
+	if err != nil {
+		u.logger.Error("failed to update records for domain", "domain", domain, "err", err)
+		return err
+	}
+	u.logger.Info("updated records for domain", "domain", domain, "count", len(stale))
+	return nil
+}
+
+// replaceRecords deletes the given records and recreates them with ip.
+func (u *Updater) replaceRecords(ctx context.Context, domain string, records []provider.Record, ip net.IP) error {
+	// Delete phase: delete the existing records for this domain
+	if u.dryRun {
+		u.logger.Info("dry-run: would delete records for domain", "domain", domain, "count", len(records))
+	} else {
+		u.logger.Info("deleting records for domain", "domain", domain, "count", len(records))
+		if err := u.provider.DeleteRecords(ctx, domain, records); err != nil {
+			u.logger.Error("failed to delete records for domain", "domain", domain, "err", err)
+			return err // Skip creation for this domain if deletion fails
 		}
+		u.logger.Info("deleted records for domain", "domain", domain, "count", len(records))
+	}
 
-		// Delete phase: delete all existing A records for this domain
-		if u.dryRun {
-			u.logger.Info("dry-run: would delete all records for domain", "domain", domain, "count", len(domainRecords))
-		} else {
-			u.logger.Info("deleting all records for domain", "domain", domain, "count", len(domainRecords))
-			if err := u.client.DeleteRecords(ctx, domain, domainRecords); err != nil {
-				u.logger.Error("failed to delete records for domain", "domain", domain, "err", err)
-				continue // Skip creation for this domain if deletion fails
-			}
-			u.logger.Info("deleted all records for domain", "domain", domain, "count", len(domainRecords))
-		}
-
-		// Create phase: create all updated records
-		updatedRecords := make([]spaceship.DNSRecord, len(domainRecords))
-		for i, record := range domainRecords {
-			updatedRecords[i] = spaceship.DNSRecord{
-				Domain:  record.Domain,
-				Name:    record.Name,
-				Type:    record.Type,
-				TTL:     record.TTL,
-				Content: ip.String(), // Will be set by UpdateRecords, but keeping for consistency
-			}
+	// Create phase: create all updated records, once per name
+	updatedRecords := withContent(uniqueByName(records), ip)
+
+	if u.dryRun {
+		u.logger.Info("dry-run: would create records for domain", "domain", domain, "count", len(updatedRecords))
+		return nil
+	}
+	u.logger.Info("creating records for domain", "domain", domain, "count", len(updatedRecords))
+	if err := u.provider.UpsertRecords(ctx, domain, updatedRecords); err != nil {
+		u.logger.Error("failed to create records for domain", "domain", domain, "err", err)
+		return err
+	}
+	u.logger.Info("created records for domain", "domain", domain, "count", len(updatedRecords))
+	return nil
+}
+
+// recordKeys identifies the loaded records of the family's type, for the cache.
+func (u *Updater) recordKeys(family ipcheck.Family) []string {
+	var keys []string
+	for _, record := range u.records {
+		if record.Type == family.RecordType() {
+			keys = append(keys, record.Domain+" "+record.Name+" "+record.Type)
 		}
+	}
+	sort.Strings(keys)
+	return keys
+}
 
-		if u.dryRun {
-			u.logger.Info("dry-run: would create records for domain", "domain", domain, "count", len(updatedRecords))
-		} else {
-			u.logger.Info("creating records for domain", "domain", domain, "count", len(updatedRecords))
-			if err := u.client.UpdateRecords(ctx, domain, updatedRecords, ip); err != nil {
-				u.logger.Error("failed to create records for domain", "domain", domain, "err", err)
+// restoreSet returns the snapshot records sharing a type and name with any of
+// the touched records, i.e. everything a failed write may have clobbered.
+func restoreSet(snapshot, touched []provider.Record) []provider.Record {
+	names := make(map[string]bool, len(touched))
+	for _, record := range touched {
+		names[record.Type+" "+record.Name] = true
+	}
+	var res []provider.Record
+	for _, record := range snapshot {
+		if names[record.Type+" "+record.Name] {
+			res = append(res, record)
+		}
+	}
+	return res
+}
+
+// markUpdated records the new content of written records so later cycles
+// compare against what is live rather than what was loaded at startup. Each
+// written set now holds a single record, so round-robin duplicates are
+// dropped.
+func (u *Updater) markUpdated(domain string, written []provider.Record, ip net.IP) {
+	names := make(map[string]bool, len(written))
+	for _, record := range written {
+		names[record.Type+" "+record.Name] = true
+	}
+	seen := make(map[string]bool, len(names))
+	kept := u.records[:0]
+	for _, record := range u.records {
+		key := record.Type + " " + record.Name
+		if record.Domain == domain && names[key] {
+			if seen[key] {
 				continue
 			}
-			u.logger.Info("created records for domain", "domain", domain, "count", len(updatedRecords))
+			seen[key] = true
+			record.Content = ip.String()
 		}
+		kept = append(kept, record)
 	}
+	u.records = kept
+}
 
-	return nil
+// staleRecords returns the records whose address differs from ip, with at
+// most one entry per name so round-robin sets are written as a single record.
+// Addresses are compared parsed, as providers may return AAAA content in a
+// form other than the canonical one.
+func staleRecords(records []provider.Record, ip net.IP) []provider.Record {
+	var stale []provider.Record
+	for _, record := range records {
+		if !net.ParseIP(record.Content).Equal(ip) {
+			stale = append(stale, record)
+		}
+	}
+	return uniqueByName(stale)
+}
+
+// withContent returns copies of records pointing at ip.
+func withContent(records []provider.Record, ip net.IP) []provider.Record {
+	res := make([]provider.Record, len(records))
+	for i, record := range records {
+		res[i] = record
+		res[i].Content = ip.String()
+	}
+	return res
+}
+
+func uniqueByName(records []provider.Record) []provider.Record {
+	seen := make(map[string]bool, len(records))
+	res := make([]provider.Record, 0, len(records))
+	for _, record := range records {
+		key := record.Type + " " + record.Name
+		if seen[key] {
+			continue
+		}
+		seen[key] = true
+		res = append(res, record)
+	}
+	return res
 }
@@ -0,0 +1,758 @@
+package updater
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/erkki/dnsupdater/internal/cache"
+	"github.com/erkki/dnsupdater/internal/desired"
+	"github.com/erkki/dnsupdater/internal/ipcheck"
+	"github.com/erkki/dnsupdater/internal/provider"
+	"github.com/erkki/dnsupdater/internal/rules"
+	"github.com/erkki/dnsupdater/internal/spaceship"
+)
+
+type apiCall struct {
+	Method    string
+	Names     []string
+	Addresses []string
+}
+
+// fakeAPI serves a single domain and records the write calls it receives.
+type fakeAPI struct {
+	mu       sync.Mutex
+	records  string
+	conflict bool
+	failPuts int
+	// failStatus is the status of failed puts, 500 by default.
+	failStatus int
+	calls      []apiCall
+}
+
+func (f *fakeAPI) handler(t *testing.T) http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/v1/domains", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"items":[{"name":"example.com"}],"total":1}`))
+	})
+	mux.HandleFunc("/v1/dns/records/example.com", func(w http.ResponseWriter, r *http.Request) {
+		f.mu.Lock()
+		defer f.mu.Unlock()
+		if r.Method == http.MethodGet {
+			w.Write([]byte(f.records))
+			return
+		}
+		data, _ := io.ReadAll(r.Body)
+		var items []struct {
+			Name    string `json:"name"`
+			Address string `json:"address"`
+		}
+		if r.Method == http.MethodPut {
+			var payload struct {
+				Items json.RawMessage `json:"items"`
+			}
+			if err := json.Unmarshal(data, &payload); err != nil {
+				t.Errorf("decode put: %v", err)
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			data = payload.Items
+		}
+		if err := json.Unmarshal(data, &items); err != nil {
+			t.Errorf("decode %s: %v", r.Method, err)
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		call := apiCall{Method: r.Method}
+		for _, item := range items {
+			call.Names = append(call.Names, item.Name)
+			call.Addresses = append(call.Addresses, item.Address)
+		}
+		f.calls = append(f.calls, call)
+		if r.Method == http.MethodPut && f.conflict {
+			f.conflict = false
+			w.WriteHeader(http.StatusConflict)
+			w.Write([]byte(`{"detail":"record conflict"}`))
+			return
+		}
+		if r.Method == http.MethodPut && f.failPuts > 0 {
+			f.failPuts--
+			status := f.failStatus
+			if status == 0 {
+				status = http.StatusInternalServerError
+			}
+			w.WriteHeader(status)
+			w.Write([]byte(`{"detail":"internal error"}`))
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	})
+	return mux
+}
+
+func newTestUpdater(t *testing.T, api *fakeAPI, ip string, strategy Strategy) *Updater {
+	t.Helper()
+	return newTestUpdaterWithCache(t, api, ip, strategy, cache.NewMemoryCache(), nil)
+}
+
+func newTestUpdaterWithCache(t *testing.T, api *fakeAPI, ip string, strategy Strategy, c cache.Store, selector *rules.Selector) *Updater {
+	t.Helper()
+	srv := httptest.NewServer(api.handler(t))
+	t.Cleanup(srv.Close)
+
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	fetcher := ipcheck.NewFetcher(ipcheck.IPv4, nil, nil, net.ParseIP(ip))
+	client := spaceship.NewClient(srv.URL, "key", "secret", srv.Client())
+	u := New(logger, []*ipcheck.Fetcher{fetcher}, c, client, Options{PollInterval: time.Hour, Strategy: strategy, Selector: selector})
+	if err := u.LoadRecords(context.Background()); err != nil {
+		t.Fatalf("load records: %v", err)
+	}
+	return u
+}
+
+const testRecords = `{"items":[
+	{"name":"@","type":"A","ttl":300,"address":"198.51.100.1"},
+	{"name":"www","type":"A","ttl":300,"address":"198.51.100.9"},
+	{"name":"vpn","type":"A","ttl":300,"address":"203.0.113.7"}
+],"total":3}`
+
+func TestSyncUpsertsOnlyStaleRecords(t *testing.T) {
+	api := &fakeAPI{records: testRecords}
+	u := newTestUpdater(t, api, "203.0.113.7", StrategyUpsert)
+
+	if _, err := u.Sync(context.Background()); err != nil {
+		t.Fatalf("sync failed: %v", err)
+	}
+	if len(api.calls) != 1 {
+		t.Fatalf("expected a single write, got %+v", api.calls)
+	}
+	call := api.calls[0]
+	if call.Method != http.MethodPut || len(call.Names) != 2 || call.Names[0] != "@" || call.Names[1] != "www" {
+		t.Fatalf("unexpected write: %+v", call)
+	}
+}
+
+func TestSyncUpsertFallsBackOnConflict(t *testing.T) {
+	api := &fakeAPI{records: testRecords, conflict: true}
+	u := newTestUpdater(t, api, "203.0.113.7", StrategyUpsert)
+
+	if _, err := u.Sync(context.Background()); err != nil {
+		t.Fatalf("sync failed: %v", err)
+	}
+	want := []string{http.MethodPut, http.MethodDelete, http.MethodPut}
+	if len(api.calls) != len(want) {
+		t.Fatalf("expected %d writes, got %+v", len(want), api.calls)
+	}
+	for i, call := range api.calls {
+		if call.Method != want[i] || len(call.Names) != 2 {
+			t.Fatalf("unexpected write %d: %+v", i, call)
+		}
+	}
+}
+
+func TestSyncReplaceStrategy(t *testing.T) {
+	api := &fakeAPI{records: testRecords}
+	u := newTestUpdater(t, api, "203.0.113.7", StrategyReplace)
+
+	if _, err := u.Sync(context.Background()); err != nil {
+		t.Fatalf("sync failed: %v", err)
+	}
+	if len(api.calls) != 2 || api.calls[0].Method != http.MethodDelete || len(api.calls[0].Names) != 3 {
+		t.Fatalf("expected delete of every A record then create, got %+v", api.calls)
+	}
+}
+
+func TestSyncRollsBackFailedDomain(t *testing.T) {
+	api := &fakeAPI{records: testRecords, failPuts: 1}
+	c := cache.NewMemoryCache()
+	u := newTestUpdaterWithCache(t, api, "203.0.113.7", StrategyReplace, c, nil)
+
+	result, err := u.Sync(context.Background())
+	if err == nil {
+		t.Fatalf("expected sync to report the failed domain")
+	}
+	if len(result.Domains) != 1 {
+		t.Fatalf("expected one domain result, got %+v", result.Domains)
+	}
+	domain := result.Domains[0]
+	if domain.Err == nil || !domain.RolledBack || domain.RollbackErr != nil {
+		t.Fatalf("expected successful rollback, got %+v", domain)
+	}
+
+	// delete, failed create, restore of the original addresses
+	if len(api.calls) != 3 {
+		t.Fatalf("expected 3 writes, got %+v", api.calls)
+	}
+	restore := api.calls[2]
+	want := map[string]string{"@": "198.51.100.1", "www": "198.51.100.9", "vpn": "203.0.113.7"}
+	if restore.Method != http.MethodPut || len(restore.Names) != len(want) {
+		t.Fatalf("unexpected restore call: %+v", restore)
+	}
+	for i, name := range restore.Names {
+		if restore.Addresses[i] != want[name] {
+			t.Fatalf("record %s restored to %s, want %s", name, restore.Addresses[i], want[name])
+		}
+	}
+
+	if entry, _ := c.Load("ipv4"); entry != nil {
+		t.Fatalf("expected IP not to be cached after a failed update, got %s", entry.IP)
+	}
+}
+
+func TestSyncSkipsUnselectedRecords(t *testing.T) {
+	api := &fakeAPI{records: testRecords}
+	c := cache.NewMemoryCache()
+	if err := c.Save("ipv4", cache.Entry{IP: net.ParseIP("198.51.100.1"), UpdatedAt: time.Now()}); err != nil {
+		t.Fatalf("seed cache: %v", err)
+	}
+	selector, err := rules.New([]rules.Rule{{Content: rules.LastIP}}, []rules.Rule{{Name: "vpn"}})
+	if err != nil {
+		t.Fatalf("compile rules: %v", err)
+	}
+	u := newTestUpdaterWithCache(t, api, "203.0.113.8", StrategyReplace, c, selector)
+
+	if _, err := u.Sync(context.Background()); err != nil {
+		t.Fatalf("sync failed: %v", err)
+	}
+	// Only "@" still points at the previously applied IP; www is not
+	// included and vpn is excluded.
+	if len(api.calls) != 2 {
+		t.Fatalf("expected delete and create, got %+v", api.calls)
+	}
+	for _, call := range api.calls {
+		if len(call.Names) != 1 || call.Names[0] != "@" {
+			t.Fatalf("unexpected write: %+v", call)
+		}
+	}
+}
+
+// recordingProvider is an in-memory provider without in-place updates.
+// Domains listed in unreadable fail to load.
+type recordingProvider struct {
+	records    []provider.Record
+	ops        []string
+	unreadable map[string]error
+}
+
+func (p *recordingProvider) Name() string { return "recording" }
+
+func (p *recordingProvider) Capabilities() provider.Capabilities {
+	return provider.Capabilities{DeleteByValue: true}
+}
+
+func (p *recordingProvider) FetchRecords(context.Context) ([]provider.Record, error) {
+	if len(p.unreadable) == 0 {
+		return p.records, nil
+	}
+	var records []provider.Record
+	for _, r := range p.records {
+		if _, failed := p.unreadable[r.Domain]; !failed {
+			records = append(records, r)
+		}
+	}
+	return records, &provider.PartialError{Errs: p.unreadable}
+}
+
+func (p *recordingProvider) UpsertRecords(_ context.Context, _ string, records []provider.Record) error {
+	for _, r := range records {
+		p.ops = append(p.ops, "add "+r.Name+" "+r.Content)
+	}
+	return nil
+}
+
+func (p *recordingProvider) DeleteRecords(_ context.Context, _ string, records []provider.Record) error {
+	for _, r := range records {
+		p.ops = append(p.ops, "delete "+r.Name+" "+r.Content)
+	}
+	return nil
+}
+
+func TestNewForcesReplaceWithoutInPlaceUpdate(t *testing.T) {
+	p := &recordingProvider{records: []provider.Record{
+		{Domain: "example.com", Name: "home", Type: "A", Content: "198.51.100.1", TTL: 300},
+	}}
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	fetcher := ipcheck.NewFetcher(ipcheck.IPv4, nil, nil, net.ParseIP("203.0.113.7"))
+	u := New(logger, []*ipcheck.Fetcher{fetcher}, cache.NewMemoryCache(), p, Options{PollInterval: time.Hour, Strategy: StrategyUpsert})
+	if err := u.LoadRecords(context.Background()); err != nil {
+		t.Fatalf("load records: %v", err)
+	}
+	if _, err := u.Sync(context.Background()); err != nil {
+		t.Fatalf("sync failed: %v", err)
+	}
+	want := []string{"delete home 198.51.100.1", "add home 203.0.113.7"}
+	if len(p.ops) != len(want) || p.ops[0] != want[0] || p.ops[1] != want[1] {
+		t.Fatalf("expected %v, got %v", want, p.ops)
+	}
+}
+
+func TestSyncReconcilesDesiredState(t *testing.T) {
+	p := &recordingProvider{records: []provider.Record{
+		{Domain: "example.com", Name: "@", Type: "A", Content: "198.51.100.1", TTL: 300},
+		{Domain: "example.com", Name: "stale", Type: "A", Content: "198.51.100.1", TTL: 300},
+		{Domain: "example.com", Name: "vps", Type: "A", Content: "192.0.2.1", TTL: 300},
+		{Domain: "other.org", Name: "@", Type: "A", Content: "192.0.2.2", TTL: 300},
+	}}
+	state, err := desired.Parse([]byte(`
+ttl: 300
+prune: true
+records:
+  - domain: example.com
+    name: "@"
+    type: A
+  - domain: example.com
+    name: www
+    type: CNAME
+    value: example.com
+`))
+	if err != nil {
+		t.Fatalf("parse state: %v", err)
+	}
+	selector, err := rules.New(nil, []rules.Rule{{Name: "vps"}})
+	if err != nil {
+		t.Fatalf("compile rules: %v", err)
+	}
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	fetcher := ipcheck.NewFetcher(ipcheck.IPv4, nil, nil, net.ParseIP("203.0.113.7"))
+	c := cache.NewMemoryCache()
+	u := New(logger, []*ipcheck.Fetcher{fetcher}, c, p, Options{PollInterval: time.Hour, Selector: selector, Desired: state})
+	if err := u.LoadRecords(context.Background()); err != nil {
+		t.Fatalf("load records: %v", err)
+	}
+
+	if _, err := u.Sync(context.Background()); err != nil {
+		t.Fatalf("sync failed: %v", err)
+	}
+	want := []string{
+		"delete @ 198.51.100.1", "add @ 203.0.113.7",
+		"delete stale 198.51.100.1",
+		"add www example.com",
+	}
+	if strings.Join(p.ops, ", ") != strings.Join(want, ", ") {
+		t.Fatalf("expected %v, got %v", want, p.ops)
+	}
+	if entry, _ := c.Load("ipv4"); entry == nil || !entry.IP.Equal(net.ParseIP("203.0.113.7")) {
+		t.Fatalf("expected the IP to be cached, got %+v", entry)
+	}
+
+	p.ops = nil
+	if _, err := u.Sync(context.Background()); err != nil {
+		t.Fatalf("second sync failed: %v", err)
+	}
+	if len(p.ops) != 0 {
+		t.Fatalf("expected no changes once reconciled, got %v", p.ops)
+	}
+}
+
+func TestReconcileDryRunDoesNotCacheIP(t *testing.T) {
+	p := &recordingProvider{records: []provider.Record{
+		{Domain: "example.com", Name: "@", Type: "A", Content: "198.51.100.1", TTL: 300},
+	}}
+	state, err := desired.Parse([]byte(`
+ttl: 300
+records:
+  - domain: example.com
+    name: "@"
+    type: A
+`))
+	if err != nil {
+		t.Fatalf("parse state: %v", err)
+	}
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	fetcher := ipcheck.NewFetcher(ipcheck.IPv4, nil, nil, net.ParseIP("203.0.113.7"))
+	c := cache.NewMemoryCache()
+	u := New(logger, []*ipcheck.Fetcher{fetcher}, c, p, Options{PollInterval: time.Hour, Desired: state, DryRun: true})
+	if err := u.LoadRecords(context.Background()); err != nil {
+		t.Fatalf("load records: %v", err)
+	}
+
+	if _, err := u.Sync(context.Background()); err != nil {
+		t.Fatalf("sync failed: %v", err)
+	}
+	if len(p.ops) != 0 {
+		t.Fatalf("expected no writes in dry-run, got %v", p.ops)
+	}
+	if entry, _ := c.Load("ipv4"); entry != nil {
+		t.Fatalf("expected the IP not to be cached in dry-run, got %+v", entry)
+	}
+}
+
+func TestSyncSkipsUnreadableDomains(t *testing.T) {
+	p := &recordingProvider{
+		records: []provider.Record{
+			{Domain: "example.com", Name: "@", Type: "A", Content: "198.51.100.1", TTL: 300},
+			{Domain: "other.org", Name: "@", Type: "A", Content: "198.51.100.1", TTL: 300},
+		},
+		unreadable: map[string]error{"other.org": errors.New("timeout")},
+	}
+	state, err := desired.Parse([]byte(`
+ttl: 300
+records:
+  - domain: example.com
+    name: "@"
+    type: A
+  - domain: other.org
+    name: "@"
+    type: A
+`))
+	if err != nil {
+		t.Fatalf("parse state: %v", err)
+	}
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	fetcher := ipcheck.NewFetcher(ipcheck.IPv4, nil, nil, net.ParseIP("203.0.113.7"))
+	u := New(logger, []*ipcheck.Fetcher{fetcher}, cache.NewMemoryCache(), p, Options{PollInterval: time.Hour, Desired: state})
+	if err := u.LoadRecords(context.Background()); err != nil {
+		t.Fatalf("partial load should succeed: %v", err)
+	}
+
+	_, err = u.Sync(context.Background())
+	var partial *provider.PartialError
+	if !errors.As(err, &partial) {
+		t.Fatalf("expected the unreadable domain to be reported, got %v", err)
+	}
+	want := []string{"delete @ 198.51.100.1", "add @ 203.0.113.7"}
+	if strings.Join(p.ops, ", ") != strings.Join(want, ", ") {
+		t.Fatalf("expected only example.com to change, got %v", p.ops)
+	}
+
+	p.ops = nil
+	p.unreadable = nil
+	p.records[0].Content = "203.0.113.7"
+	if err := u.LoadRecords(context.Background()); err != nil {
+		t.Fatalf("load records: %v", err)
+	}
+	if _, err := u.Sync(context.Background()); err != nil {
+		t.Fatalf("sync failed: %v", err)
+	}
+	if strings.Join(p.ops, ", ") != strings.Join(want, ", ") {
+		t.Fatalf("expected other.org to change once readable, got %v", p.ops)
+	}
+}
+
+func TestPlanAndApply(t *testing.T) {
+	api := &fakeAPI{records: testRecords}
+	u := newTestUpdater(t, api, "203.0.113.7", StrategyUpsert)
+
+	plan, err := u.Plan(context.Background())
+	if err != nil {
+		t.Fatalf("plan failed: %v", err)
+	}
+	if len(api.calls) != 0 {
+		t.Fatalf("plan must not write, got %+v", api.calls)
+	}
+	if len(plan.Changes) != 2 || plan.Summary() != "0 to create, 2 to update, 0 to delete" {
+		t.Fatalf("unexpected plan: %+v", plan.Changes)
+	}
+	var table strings.Builder
+	if err := plan.WriteTable(&table); err != nil {
+		t.Fatalf("write table: %v", err)
+	}
+	if !strings.Contains(table.String(), "198.51.100.9 (ttl 300)") || !strings.Contains(table.String(), "203.0.113.7 (ttl 300)") {
+		t.Fatalf("table lacks old and new content:\n%s", table.String())
+	}
+
+	path := filepath.Join(t.TempDir(), "plan.json")
+	if err := plan.Save(path); err != nil {
+		t.Fatalf("save plan: %v", err)
+	}
+	if files, _ := os.ReadDir(filepath.Dir(path)); len(files) != 1 {
+		t.Fatalf("expected only the plan to be left behind, got %v", files)
+	}
+	saved, err := LoadPlan(path)
+	if err != nil {
+		t.Fatalf("load plan: %v", err)
+	}
+
+	// Someone edits www in the dashboard before the plan is applied.
+	api.mu.Lock()
+	api.records = strings.Replace(testRecords, "198.51.100.9", "198.51.100.10", 1)
+	api.mu.Unlock()
+	if _, err := u.Apply(context.Background(), saved); !errors.Is(err, desired.ErrStale) {
+		t.Fatalf("expected a stale plan to be refused, got %v", err)
+	}
+	if len(api.calls) != 0 {
+		t.Fatalf("stale plan must not write, got %+v", api.calls)
+	}
+
+	api.mu.Lock()
+	api.records = testRecords
+	api.mu.Unlock()
+	results, err := u.Apply(context.Background(), saved)
+	if err != nil {
+		t.Fatalf("apply failed: %v", err)
+	}
+	if len(results) != 1 || len(api.calls) != 2 {
+		t.Fatalf("expected one write per planned set, got %+v", api.calls)
+	}
+}
+
+func TestSyncFixesDriftWhenIPUnchanged(t *testing.T) {
+	api := &fakeAPI{records: testRecords}
+	c := cache.NewMemoryCache()
+	if err := c.Save("ipv4", cache.Entry{IP: net.ParseIP("203.0.113.7"), UpdatedAt: time.Now()}); err != nil {
+		t.Fatalf("seed cache: %v", err)
+	}
+	srv := httptest.NewServer(api.handler(t))
+	t.Cleanup(srv.Close)
+
+	var logs strings.Builder
+	logger := slog.New(slog.NewTextHandler(&logs, nil))
+	fetcher := ipcheck.NewFetcher(ipcheck.IPv4, nil, nil, net.ParseIP("203.0.113.7"))
+	client := spaceship.NewClient(srv.URL, "key", "secret", srv.Client())
+	u := New(logger, []*ipcheck.Fetcher{fetcher}, c, client, Options{PollInterval: time.Hour, Strategy: StrategyUpsert})
+	if err := u.LoadRecords(context.Background()); err != nil {
+		t.Fatalf("load records: %v", err)
+	}
+
+	if _, err := u.Sync(context.Background()); err != nil {
+		t.Fatalf("sync failed: %v", err)
+	}
+	if len(api.calls) != 1 || len(api.calls[0].Names) != 2 {
+		t.Fatalf("expected the drifted records to be fixed, got %+v", api.calls)
+	}
+	if !strings.Contains(logs.String(), "record drifted from current IP") {
+		t.Fatalf("expected drift to be logged:\n%s", logs.String())
+	}
+
+	// A dashboard edit and a new subdomain show up on the next refresh.
+	api.mu.Lock()
+	api.records = `{"items":[
+		{"name":"@","type":"A","ttl":300,"address":"203.0.113.7"},
+		{"name":"www","type":"A","ttl":300,"address":"198.51.100.9"},
+		{"name":"vpn","type":"A","ttl":300,"address":"203.0.113.7"},
+		{"name":"new","type":"A","ttl":300,"address":"198.51.100.4"}
+	],"total":4}`
+	api.calls = nil
+	api.mu.Unlock()
+	logs.Reset()
+	if err := u.LoadRecords(context.Background()); err != nil {
+		t.Fatalf("reload records: %v", err)
+	}
+	for _, want := range []string{"record changed outside the updater", "name=www", "record added outside the updater", "name=new"} {
+		if !strings.Contains(logs.String(), want) {
+			t.Fatalf("expected %q in logs:\n%s", want, logs.String())
+		}
+	}
+	if _, err := u.Sync(context.Background()); err != nil {
+		t.Fatalf("sync failed: %v", err)
+	}
+	if len(api.calls) != 1 {
+		t.Fatalf("expected a single write, got %+v", api.calls)
+	}
+	names := append([]string(nil), api.calls[0].Names...)
+	sort.Strings(names)
+	if strings.Join(names, ",") != "new,www" {
+		t.Fatalf("expected www and new to be fixed, got %+v", api.calls)
+	}
+}
+
+func TestRunRetriesFailedSyncBeforeNextPoll(t *testing.T) {
+	api := &fakeAPI{records: testRecords, failPuts: 1000}
+	srv := httptest.NewServer(api.handler(t))
+	t.Cleanup(srv.Close)
+
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	fetcher := ipcheck.NewFetcher(ipcheck.IPv4, nil, nil, net.ParseIP("203.0.113.7"))
+	client := spaceship.NewClient(srv.URL, "key", "secret", srv.Client())
+	client.SetRateLimits(spaceship.RateLimits{})
+	u := New(logger, []*ipcheck.Fetcher{fetcher}, cache.NewMemoryCache(), client, Options{
+		PollInterval:  time.Hour,
+		Strategy:      StrategyUpsert,
+		RetryInterval: 5 * time.Millisecond,
+	})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+	if err := u.Run(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("unexpected run error: %v", err)
+	}
+
+	api.mu.Lock()
+	defer api.mu.Unlock()
+	// Each failed sync makes one write and one rollback write.
+	if syncs := len(api.calls) / 2; syncs < 3 {
+		t.Fatalf("expected the failed sync to be retried, got %d attempts", syncs)
+	}
+}
+
+func TestSyncSkipsMissingDomain(t *testing.T) {
+	api := &fakeAPI{records: testRecords, failPuts: 1, failStatus: http.StatusNotFound}
+	c := cache.NewMemoryCache()
+	u := newTestUpdaterWithCache(t, api, "203.0.113.7", StrategyUpsert, c, nil)
+
+	result, err := u.Sync(context.Background())
+	if err != nil {
+		t.Fatalf("a missing domain must not fail the sync: %v", err)
+	}
+	if len(result.Domains) != 1 || !result.Domains[0].Skipped || result.Domains[0].RolledBack {
+		t.Fatalf("expected the domain to be skipped without rollback, got %+v", result.Domains)
+	}
+	if len(api.calls) != 1 {
+		t.Fatalf("expected no rollback write, got %+v", api.calls)
+	}
+	if entry, _ := c.Load("ipv4"); entry == nil {
+		t.Fatalf("expected the IP to be cached")
+	}
+}
+
+func TestSyncReportsRejectedCredentials(t *testing.T) {
+	api := &fakeAPI{records: testRecords, failPuts: 1, failStatus: http.StatusUnauthorized}
+	u := newTestUpdater(t, api, "203.0.113.7", StrategyUpsert)
+
+	_, err := u.Sync(context.Background())
+	if !errors.Is(err, provider.ErrUnauthorized) || !spaceship.IsUnauthorized(err) {
+		t.Fatalf("expected an unauthorized error, got %v", err)
+	}
+	var apiErr *spaceship.APIError
+	if !errors.As(err, &apiErr) || apiErr.StatusCode != http.StatusUnauthorized {
+		t.Fatalf("expected the API error to be preserved, got %v", err)
+	}
+}
+
+func TestSyncChangesNothingWithoutConsensus(t *testing.T) {
+	var urls []string
+	for _, answer := range []string{"203.0.113.7", "198.51.100.66"} {
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte(answer))
+		}))
+		t.Cleanup(srv.Close)
+		urls = append(urls, srv.URL)
+	}
+	v4 := ipcheck.NewFetcher(ipcheck.IPv4, &http.Client{Timeout: time.Second}, urls, nil)
+	v4.SetMode(ipcheck.ModeQuorum)
+	v6 := ipcheck.NewFetcher(ipcheck.IPv6, nil, nil, net.ParseIP("2001:db8::7"))
+
+	p := &recordingProvider{records: []provider.Record{
+		{Domain: "example.com", Name: "@", Type: "A", Content: "192.0.2.1", TTL: 300},
+		{Domain: "example.com", Name: "@", Type: "AAAA", Content: "2001:db8::1", TTL: 300},
+	}}
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	u := New(logger, []*ipcheck.Fetcher{v4, v6}, cache.NewMemoryCache(), p, Options{PollInterval: time.Hour})
+	if err := u.LoadRecords(context.Background()); err != nil {
+		t.Fatalf("load records: %v", err)
+	}
+
+	_, err := u.Sync(context.Background())
+	if !errors.Is(err, ipcheck.ErrNoConsensus) {
+		t.Fatalf("expected no consensus, got %v", err)
+	}
+	if len(p.ops) != 0 {
+		t.Fatalf("expected no changes, not even to AAAA records, got %v", p.ops)
+	}
+}
+
+func TestSyncDryRunDoesNotCacheIP(t *testing.T) {
+	api := &fakeAPI{records: testRecords}
+	srv := httptest.NewServer(api.handler(t))
+	t.Cleanup(srv.Close)
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	fetcher := ipcheck.NewFetcher(ipcheck.IPv4, nil, nil, net.ParseIP("203.0.113.7"))
+	client := spaceship.NewClient(srv.URL, "key", "secret", srv.Client())
+	c := cache.NewMemoryCache()
+	u := New(logger, []*ipcheck.Fetcher{fetcher}, c, client, Options{PollInterval: time.Hour, Strategy: StrategyUpsert, DryRun: true})
+	if err := u.LoadRecords(context.Background()); err != nil {
+		t.Fatalf("load records: %v", err)
+	}
+
+	if _, err := u.Sync(context.Background()); err != nil {
+		t.Fatalf("sync failed: %v", err)
+	}
+	if len(api.calls) != 0 {
+		t.Fatalf("expected no writes in dry-run, got %+v", api.calls)
+	}
+	if entry, _ := c.Load("ipv4"); entry != nil {
+		t.Fatalf("expected the IP not to be cached in dry-run, got %+v", entry)
+	}
+}
+
+func TestStaleRecordsComparesAddresses(t *testing.T) {
+	records := []provider.Record{
+		{Domain: "example.com", Name: "home", Type: "AAAA", Content: "2001:DB8:0:0::1"},
+		{Domain: "example.com", Name: "vps", Type: "AAAA", Content: "2001:db8::2"},
+	}
+	stale := staleRecords(records, net.ParseIP("2001:db8::1"))
+	if len(stale) != 1 || stale[0].Name != "vps" {
+		t.Fatalf("expected only vps to be stale, got %+v", stale)
+	}
+}
+
+// dualStackRecords holds an A and an AAAA record for two names.
+func dualStackRecords() []provider.Record {
+	return []provider.Record{
+		{Domain: "example.com", Name: "@", Type: "A", Content: "198.51.100.1", TTL: 300},
+		{Domain: "example.com", Name: "@", Type: "AAAA", Content: "2001:db8::1", TTL: 300},
+		{Domain: "example.com", Name: "home", Type: "A", Content: "198.51.100.1", TTL: 300},
+		{Domain: "example.com", Name: "home", Type: "AAAA", Content: "2001:db8::1", TTL: 300},
+	}
+}
+
+func TestSyncUpdatesBothFamilies(t *testing.T) {
+	p := &recordingProvider{records: dualStackRecords()}
+	v4 := ipcheck.NewFetcher(ipcheck.IPv4, nil, nil, net.ParseIP("203.0.113.7"))
+	v6 := ipcheck.NewFetcher(ipcheck.IPv6, nil, nil, net.ParseIP("2001:db8::7"))
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	c := cache.NewMemoryCache()
+	u := New(logger, []*ipcheck.Fetcher{v4, v6}, c, p, Options{PollInterval: time.Hour})
+	if err := u.LoadRecords(context.Background()); err != nil {
+		t.Fatalf("load records: %v", err)
+	}
+
+	if _, err := u.Sync(context.Background()); err != nil {
+		t.Fatalf("sync failed: %v", err)
+	}
+	want := []string{
+		"delete @ 198.51.100.1", "delete home 198.51.100.1", "add @ 203.0.113.7", "add home 203.0.113.7",
+		"delete @ 2001:db8::1", "delete home 2001:db8::1", "add @ 2001:db8::7", "add home 2001:db8::7",
+	}
+	if strings.Join(p.ops, ", ") != strings.Join(want, ", ") {
+		t.Fatalf("expected %v, got %v", want, p.ops)
+	}
+	for family, ip := range map[string]string{"ipv4": "203.0.113.7", "ipv6": "2001:db8::7"} {
+		if entry, _ := c.Load(family); entry == nil || !entry.IP.Equal(net.ParseIP(ip)) {
+			t.Errorf("expected %s to be cached for %s, got %+v", ip, family, entry)
+		}
+	}
+}
+
+func TestSyncUpdatesIPv4WhenIPv6Fails(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "unavailable", http.StatusServiceUnavailable)
+	}))
+	t.Cleanup(srv.Close)
+
+	p := &recordingProvider{records: dualStackRecords()}
+	v4 := ipcheck.NewFetcher(ipcheck.IPv4, nil, nil, net.ParseIP("203.0.113.7"))
+	v6 := ipcheck.NewFetcher(ipcheck.IPv6, srv.Client(), []string{srv.URL}, nil)
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	c := cache.NewMemoryCache()
+	u := New(logger, []*ipcheck.Fetcher{v4, v6}, c, p, Options{PollInterval: time.Hour})
+	if err := u.LoadRecords(context.Background()); err != nil {
+		t.Fatalf("load records: %v", err)
+	}
+
+	if _, err := u.Sync(context.Background()); err != nil {
+		t.Fatalf("sync failed: %v", err)
+	}
+	want := []string{"delete @ 198.51.100.1", "delete home 198.51.100.1", "add @ 203.0.113.7", "add home 203.0.113.7"}
+	if strings.Join(p.ops, ", ") != strings.Join(want, ", ") {
+		t.Fatalf("expected only the A records to change, got %v", p.ops)
+	}
+	if entry, _ := c.Load("ipv4"); entry == nil || !entry.IP.Equal(net.ParseIP("203.0.113.7")) {
+		t.Fatalf("expected the IPv4 address to be cached, got %+v", entry)
+	}
+	if entry, _ := c.Load("ipv6"); entry != nil {
+		t.Fatalf("expected nothing cached for IPv6, got %+v", entry)
+	}
+}